@@ -1,20 +1,76 @@
 package main
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"os"
+	"os/signal"
+	"syscall"
 )
 import "dev.hackerman.me/artheon/artheon-rpc/web"
 
 func main() {
 	_ = viper.BindEnv("web.host", "WEB_HOST")
 	_ = viper.BindEnv("web.port", "WEB_PORT")
+	_ = viper.BindEnv("web.shutdownTimeout", "WEB_SHUTDOWN_TIMEOUT")
+	_ = viper.BindEnv("rpc.backplane.driver", "RPC_BACKPLANE_DRIVER")
+	_ = viper.BindEnv("rpc.admission.clientIpHeader", "RPC_ADMISSION_CLIENT_IP_HEADER")
+	_ = viper.BindEnv("rpc.admission.maxConnectionsPerUser", "RPC_ADMISSION_MAX_CONNECTIONS_PER_USER")
+	_ = viper.BindEnv("auth.jwt.secret", "AUTH_JWT_SECRET")
+	_ = viper.BindEnv("auth.jwt.issuer", "AUTH_JWT_ISSUER")
+	_ = viper.BindEnv("auth.jwt.audience", "AUTH_JWT_AUDIENCE")
 
 	viper.SetDefault("web.host", "0.0.0.0")
 	viper.SetDefault("web.port", "8080")
+	viper.SetDefault("web.shutdownTimeout", "25s")
+	viper.SetDefault("auth.jwt.issuer", "artheon-api")
+	viper.SetDefault("auth.jwt.audience", "artheon-rpc")
+
+	viper.SetDefault("rpc.rateLimit.connection.rate", 20)
+	viper.SetDefault("rpc.rateLimit.connection.burst", 40)
+	viper.SetDefault("rpc.rateLimit.user.rate", 30)
+	viper.SetDefault("rpc.rateLimit.user.burst", 60)
+	viper.SetDefault("rpc.rateLimit.methods.chat.channelSend.rate", 2)
+	viper.SetDefault("rpc.rateLimit.methods.chat.channelSend.burst", 5)
+	viper.SetDefault("rpc.rateLimit.methods.analytics.userAction.rate", 5)
+	viper.SetDefault("rpc.rateLimit.methods.analytics.userAction.burst", 20)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxGetLoginToken.rate", 1)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxGetLoginToken.burst", 5)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxGetJoinToken.rate", 1)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxGetJoinToken.burst", 5)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxMute.rate", 0.5)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxMute.burst", 3)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxUnmute.rate", 0.5)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxUnmute.burst", 3)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxKick.rate", 0.5)
+	viper.SetDefault("rpc.rateLimit.methods.vivox.vivoxKick.burst", 3)
+	viper.SetDefault("rpc.rateLimit.maxViolations", 20)
+	viper.SetDefault("rpc.rateLimit.violationWindow", "1m")
+
+	viper.SetDefault("rpc.backplane.driver", "memory")
+	viper.SetDefault("rpc.backplane.channel", "artheon_rpc")
+
+	viper.SetDefault("rpc.session.resumeWindow", "2m")
+	viper.SetDefault("rpc.session.bufferSize", 256)
+	viper.SetDefault("rpc.session.ackTimeout", "10s")
+	viper.SetDefault("rpc.session.maxRetransmits", 3)
+
+	viper.SetDefault("rpc.outbound.queueSize", 256)
+	viper.SetDefault("rpc.outbound.policy", "dropOldest")
+
+	viper.SetDefault("rpc.chat.backfillLimit", 50)
+	viper.SetDefault("rpc.chat.backlogLimit", 100)
+	viper.SetDefault("rpc.chat.maxGroupMembers", 50)
+
+	// Connection limits are enforced by each instance separately.
+	viper.SetDefault("rpc.admission.maxConnections", 10000)
+	viper.SetDefault("rpc.admission.maxConnectionsPerIp", 100)
+	viper.SetDefault("rpc.admission.maxConnectionsPerUser", 5)
+	viper.SetDefault("rpc.admission.authTimeout", "10s")
 
 	//todo debug
-	//c, err := models.RequestKick(models.VivoxTokenPayload{})
+	//c, err := models.NewVivoxClient().RequestKick(models.VivoxTokenPayload{})
 	//if err != nil {
 	//     fmt.Printf("%s", err.Error())
 	//} else {
@@ -25,9 +81,46 @@ func main() {
 		viper.GetString("web.host"),
 		viper.GetString("web.port")
 
-	webServer := web.NewWebServer(webHost, webPort)
+	dbUrl := web.GetDatabaseUrl()
+
+	db, err := web.OpenDatabase(dbUrl)
+	if err != nil {
+		log.Fatalf("Unable to open database: %s", err.Error())
+	}
+
+	websocketConfig, err := web.NewWebsocketServerConfigFromConfig(db, dbUrl)
+	if err != nil {
+		log.Fatalf("Unable to configure websocket server: %s", err.Error())
+	}
+
+	websocketServer, err := web.NewWebsocketServer(websocketConfig, db)
+	if err != nil {
+		log.Fatalf("Unable to create websocket server: %s", err.Error())
+	}
+
+	webServer := web.NewWebServer(webHost, webPort, websocketServer)
 
 	log.Infof("Starting web server... Host:%s, Port:%s", webHost, webPort)
 
-	webServer.Start()
+	go webServer.Start()
+
+	// Wait for the termination signal.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	log.Infof("Received %s, shutting down web server...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("web.shutdownTimeout"))
+	defer cancel()
+
+	if err := webServer.Shutdown(ctx); err != nil {
+		log.Errorf("Web server shutdown error: %s", err.Error())
+	}
+
+	if err := db.Close(); err != nil {
+		log.Errorf("Database close error: %s", err.Error())
+	}
+
+	log.Info("Web server stopped")
 }
@@ -0,0 +1,4 @@
+-- Chat history pagination: messages are read by the channel in the order of (created_at, id).
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS chat_messages_channel_history_idx ON chat_messages (channel_id, created_at, id);
@@ -0,0 +1,16 @@
+-- Edited and deleted chat messages. Deleted messages are kept as tombstones, previous texts of the edited ones are kept
+-- in chat_message_edits.
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS edited_at timestamptz;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_by uuid;
+
+CREATE TABLE IF NOT EXISTS chat_message_edits
+(
+    id         bigserial PRIMARY KEY,
+    message_id uuid        NOT NULL REFERENCES chat_messages (id) ON DELETE CASCADE,
+    user_id    uuid        NOT NULL,
+    message    text        NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS chat_message_edits_message_idx ON chat_message_edits (message_id, created_at);
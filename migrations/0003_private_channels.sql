@@ -0,0 +1,19 @@
+-- Private channels of two users. The channel id is derived from the user ids by the server.
+CREATE TABLE IF NOT EXISTS private_channels
+(
+    id         uuid PRIMARY KEY,
+    host_id    uuid        NOT NULL,
+    guest_id   uuid        NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Messages sent to the channel after delivered_at are delivered to the member on the next connect.
+CREATE TABLE IF NOT EXISTS private_channel_members
+(
+    channel_id   uuid        NOT NULL REFERENCES private_channels (id) ON DELETE CASCADE,
+    user_id      uuid        NOT NULL,
+    delivered_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (channel_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS private_channel_members_user_idx ON private_channel_members (user_id);
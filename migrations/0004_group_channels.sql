@@ -0,0 +1,18 @@
+-- Group chat rooms created by the users.
+CREATE TABLE IF NOT EXISTS group_channels
+(
+    id         uuid PRIMARY KEY,
+    name       text        NOT NULL,
+    owner_id   uuid        NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS group_channel_members
+(
+    channel_id uuid        NOT NULL REFERENCES group_channels (id) ON DELETE CASCADE,
+    user_id    uuid        NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (channel_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_channel_members_user_idx ON group_channel_members (user_id);
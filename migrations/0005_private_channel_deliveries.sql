@@ -0,0 +1,9 @@
+-- Private channel messages delivered to the members by any server instance. The messages sent after delivered_at of
+-- the member without a delivery are pushed to the member on the next connect. delivered_at is no longer advanced.
+CREATE TABLE IF NOT EXISTS private_channel_deliveries
+(
+    message_id   uuid        NOT NULL REFERENCES chat_messages (id) ON DELETE CASCADE,
+    user_id      uuid        NOT NULL,
+    delivered_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (message_id, user_id)
+);
@@ -1,7 +1,5 @@
 package models
 
-import "database/sql"
-
 type Action struct {
 	Id       string `json:"id,omitempty"`
 	UserId   string `json:"userId"`
@@ -10,9 +8,9 @@ type Action struct {
 	Action   string `json:"action"`
 }
 
-func AddAction(db *sql.DB, action Action) error {
+func (store *Store) AddAction(action Action) error {
 	query := "INSERT INTO actions (sender_id, user_id, details, action) VALUES ($1, $2, $3, $4)"
-	stmt, err := db.Prepare(query)
+	stmt, err := store.db.Prepare(query)
 	if err != nil {
 		return err
 	}
@@ -26,11 +24,11 @@ func AddAction(db *sql.DB, action Action) error {
 	return err
 }
 
-func GetActionById(db *sql.DB, actionId string) (*Action, error) {
+func (store *Store) GetActionById(actionId string) (*Action, error) {
 
 	action := Action{}
 
-	err := db.QueryRow(
+	err := store.db.QueryRow(
 		"SELECT id, action, details, user_id, sender_id FROM actions AS a WHERE id = $1",
 		actionId,
 	).Scan(&action.Id, &action.Action, &action.Details, &action.UserId, &action.SenderId)
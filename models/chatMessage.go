@@ -2,7 +2,10 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/google/uuid"
+	"strings"
+	"time"
 )
 
 type ChatMessage struct {
@@ -12,21 +15,194 @@ type ChatMessage struct {
 	ChannelId       string    `json:"channelId"`
 	ChannelName     string    `json:"channelName"`
 	ChannelCategory string    `json:"channelCategory"`
+	CreatedAt       time.Time `json:"createdAt"`
+	// Zero unless the message has been edited or deleted.
+	EditedAt  time.Time `json:"editedAt"`
+	DeletedAt time.Time `json:"deletedAt"`
 }
 
-func AddChatMessage(db *sql.DB, m ChatMessage) error {
-	query := "INSERT INTO chat_messages (user_id, message, channel_id, channel_name, channel_category) VALUES ($1, $2, $3, $4, $5)"
-	stmt, err := db.Prepare(query)
+const chatMessageColumns = "m.id, m.user_id, m.message, m.channel_id, m.channel_name, m.channel_category, m.created_at, m.edited_at, m.deleted_at"
+
+// Scans the row of the chat message columns. The text of the deleted messages is not returned.
+func scanChatMessage(row interface {
+	Scan(dest ...interface{}) error
+}) (*ChatMessage, error) {
+	m := ChatMessage{}
+	var editedAt, deletedAt sql.NullTime
+
+	err := row.Scan(&m.Id, &m.UserId, &m.Message, &m.ChannelId, &m.ChannelName, &m.ChannelCategory, &m.CreatedAt, &editedAt, &deletedAt)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	m.EditedAt = editedAt.Time
+	m.DeletedAt = deletedAt.Time
+	if deletedAt.Valid {
+		m.Message = ""
 	}
 
-	_, err = stmt.Exec(
+	return &m, nil
+}
+
+// Page of the channel messages to read. Cursors left zero are not applied.
+type ChatMessageQuery struct {
+	ChannelId string
+	// Messages sent before or after the message with the id.
+	BeforeId string
+	AfterId  string
+	// Messages sent before or after the time.
+	Before time.Time
+	After  time.Time
+	// Maximal number of the messages.
+	Limit int
+}
+
+// Stores the message. Returns the message with the id and the time assigned by the database.
+func (store *Store) AddChatMessage(m ChatMessage) (*ChatMessage, error) {
+	return scanChatMessage(store.db.QueryRow(
+		"INSERT INTO chat_messages AS m (user_id, message, channel_id, channel_name, channel_category) VALUES ($1, $2, $3, $4, $5) RETURNING "+chatMessageColumns,
 		m.UserId,
 		m.Message,
 		m.ChannelId,
 		m.ChannelName,
-		m.ChannelCategory)
+		m.ChannelCategory,
+	))
+}
+
+func (store *Store) GetChatMessageById(id string) (*ChatMessage, error) {
+	return scanChatMessage(store.db.QueryRow(
+		"SELECT "+chatMessageColumns+" FROM chat_messages AS m WHERE m.id = $1",
+		id,
+	))
+}
+
+// Conditions of the message which may be changed: it has not been deleted and, unless the author id is nil, it has been
+// sent by the author. The author id is the third argument of the query.
+func getChatMessageChangeConditions(authorId uuid.UUID) string {
+	if authorId == uuid.Nil {
+		return "m.id = $1 AND m.deleted_at IS NULL"
+	}
+	return "m.id = $1 AND m.deleted_at IS NULL AND m.user_id = $3"
+}
+
+// Appends the author id to the arguments of the query unless it is nil.
+func appendChatMessageAuthor(args []interface{}, authorId uuid.UUID) []interface{} {
+	if authorId == uuid.Nil {
+		return args
+	}
+	return append(args, authorId)
+}
+
+// Replaces the text of the message keeping the previous one in the edit history. Only the message of the author is
+// edited unless the author id is nil. Returns sql.ErrNoRows if the message does not exist, has been deleted or has been
+// sent by another user.
+func (store *Store) EditChatMessage(id string, authorId uuid.UUID, editorId uuid.UUID, message string) (*ChatMessage, error) {
+	conditions := getChatMessageChangeConditions(authorId)
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(
+		"INSERT INTO chat_message_edits (message_id, user_id, message) SELECT m.id, $2, m.message FROM chat_messages AS m WHERE "+conditions+" FOR UPDATE",
+		appendChatMessageAuthor([]interface{}{id, editorId}, authorId)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := scanChatMessage(tx.QueryRow(
+		"UPDATE chat_messages AS m SET message = $2, edited_at = now() WHERE "+conditions+" RETURNING "+chatMessageColumns,
+		appendChatMessageAuthor([]interface{}{id, message}, authorId)...,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return m, tx.Commit()
+}
+
+// Marks the message deleted, the message is kept as a tombstone. Only the message of the author is deleted unless the
+// author id is nil. Returns sql.ErrNoRows if the message does not exist, has already been deleted or has been sent by
+// another user.
+func (store *Store) DeleteChatMessage(id string, authorId uuid.UUID, deleterId uuid.UUID) (*ChatMessage, error) {
+	return scanChatMessage(store.db.QueryRow(
+		"UPDATE chat_messages AS m SET deleted_at = now(), deleted_by = $2 WHERE "+getChatMessageChangeConditions(authorId)+" RETURNING "+chatMessageColumns,
+		appendChatMessageAuthor([]interface{}{id, deleterId}, authorId)...,
+	))
+}
+
+// Returns the channel messages in the chronological order and whether there are more messages beyond the page.
+// The page follows the after cursor if it is the only one set, otherwise it precedes the before cursor or ends with the
+// latest message.
+func (store *Store) GetChatMessages(query ChatMessageQuery) ([]ChatMessage, bool, error) {
+	conditions := []string{"m.channel_id = $1"}
+	args := []interface{}{query.ChannelId}
+
+	addCondition := func(format string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if query.BeforeId != "" {
+		addCondition("(m.created_at, m.id) < (SELECT created_at, id FROM chat_messages WHERE id = $%d)", query.BeforeId)
+	}
+	if query.AfterId != "" {
+		addCondition("(m.created_at, m.id) > (SELECT created_at, id FROM chat_messages WHERE id = $%d)", query.AfterId)
+	}
+	if !query.Before.IsZero() {
+		addCondition("m.created_at < $%d", query.Before)
+	}
+	if !query.After.IsZero() {
+		addCondition("m.created_at > $%d", query.After)
+	}
+
+	forward := (query.AfterId != "" || !query.After.IsZero()) && query.BeforeId == "" && query.Before.IsZero()
+
+	order := "DESC"
+	if forward {
+		order = "ASC"
+	}
+
+	// Take one more message to find out if there are more.
+	args = append(args, query.Limit+1)
+
+	rows, err := store.db.Query(
+		fmt.Sprintf(
+			"SELECT %s FROM chat_messages AS m WHERE %s ORDER BY m.created_at %s, m.id %s LIMIT $%d",
+			chatMessageColumns, strings.Join(conditions, " AND "), order, order, len(args),
+		),
+		args...,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	messages := make([]ChatMessage, 0, query.Limit+1)
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, *m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > query.Limit
+	if hasMore {
+		messages = messages[:query.Limit]
+	}
+
+	if !forward {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
 
-	return err
+	return messages, hasMore, nil
 }
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+var ErrGroupChannelFull = errors.New("group channel has the maximal number of members")
+
+// Group chat room created by the user.
+type GroupChannel struct {
+	Id      uuid.UUID `json:"id"`
+	Name    string    `json:"name"`
+	OwnerId uuid.UUID `json:"ownerId"`
+	// Members in the order of joining, the owner included.
+	MemberIds []uuid.UUID `json:"memberIds"`
+}
+
+func (channel *GroupChannel) HasMember(userId uuid.UUID) bool {
+	for _, memberId := range channel.MemberIds {
+		if memberId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+const groupChannelQuery = `SELECT g.id, g.name, g.owner_id, m.user_id
+FROM group_channels AS g
+JOIN group_channel_members AS m ON m.channel_id = g.id
+WHERE %s
+ORDER BY g.created_at, g.id, m.created_at, m.user_id`
+
+// Reads the rows of the group channels joined with their members.
+func scanGroupChannels(rows *sql.Rows) ([]GroupChannel, error) {
+	defer rows.Close()
+
+	channels := make([]GroupChannel, 0)
+	for rows.Next() {
+		channel := GroupChannel{}
+		var memberId uuid.UUID
+		if err := rows.Scan(&channel.Id, &channel.Name, &channel.OwnerId, &memberId); err != nil {
+			return nil, err
+		}
+
+		if n := len(channels); n == 0 || channels[n-1].Id != channel.Id {
+			channels = append(channels, channel)
+		}
+		last := &channels[len(channels)-1]
+		last.MemberIds = append(last.MemberIds, memberId)
+	}
+
+	return channels, rows.Err()
+}
+
+// Stores the channel with the owner as the first member.
+func (store *Store) AddGroupChannel(channel GroupChannel) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("INSERT INTO group_channels (id, name, owner_id) VALUES ($1, $2, $3)", channel.Id, channel.Name, channel.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO group_channel_members (channel_id, user_id) VALUES ($1, $2)", channel.Id, channel.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Returns the channel with its members. Returns sql.ErrNoRows if the channel does not exist.
+func (store *Store) GetGroupChannelById(id uuid.UUID) (*GroupChannel, error) {
+	rows, err := store.db.Query(fmt.Sprintf(groupChannelQuery, "g.id = $1"), id)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := scanGroupChannels(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(channels) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &channels[0], nil
+}
+
+// Returns the channels the user is a member of.
+func (store *Store) GetGroupChannelsByUserId(userId uuid.UUID) ([]GroupChannel, error) {
+	rows, err := store.db.Query(fmt.Sprintf(groupChannelQuery, "g.id IN (SELECT channel_id FROM group_channel_members WHERE user_id = $1)"), userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanGroupChannels(rows)
+}
+
+// Adds the member unless the channel has the maximal number of members, zero allows any. Adding the existing member
+// is ignored. Returns sql.ErrNoRows if the channel does not exist.
+func (store *Store) AddGroupChannelMember(channelId uuid.UUID, userId uuid.UUID, maxMembers int) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Lock the channel, so the concurrent invitations do not exceed the limit.
+	if err = tx.QueryRow("SELECT id FROM group_channels WHERE id = $1 FOR UPDATE", channelId).Scan(&channelId); err != nil {
+		return err
+	}
+
+	var count int
+	if err = tx.QueryRow("SELECT count(*) FROM group_channel_members WHERE channel_id = $1", channelId).Scan(&count); err != nil {
+		return err
+	}
+
+	if maxMembers > 0 && count >= maxMembers {
+		return ErrGroupChannelFull
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO group_channel_members (channel_id, user_id) VALUES ($1, $2) ON CONFLICT (channel_id, user_id) DO NOTHING",
+		channelId,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Removes the member. The ownership of the channel left by the owner passes to the earliest joined member, the channel
+// left by the last member is deleted. Returns the channel after the removal, nil if it has been deleted, or
+// sql.ErrNoRows if the user is not a member.
+func (store *Store) RemoveGroupChannelMember(channelId uuid.UUID, userId uuid.UUID) (*GroupChannel, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var ownerId uuid.UUID
+	if err = tx.QueryRow("SELECT owner_id FROM group_channels WHERE id = $1 FOR UPDATE", channelId).Scan(&ownerId); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec("DELETE FROM group_channel_members WHERE channel_id = $1 AND user_id = $2", channelId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if removed, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if removed == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if ownerId == userId {
+		var nextOwnerId uuid.UUID
+		err = tx.QueryRow(
+			"SELECT user_id FROM group_channel_members WHERE channel_id = $1 ORDER BY created_at, user_id LIMIT 1",
+			channelId,
+		).Scan(&nextOwnerId)
+
+		if err == sql.ErrNoRows {
+			if _, err = tx.Exec("DELETE FROM group_channels WHERE id = $1", channelId); err != nil {
+				return nil, err
+			}
+			return nil, tx.Commit()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = tx.Exec("UPDATE group_channels SET owner_id = $2 WHERE id = $1", channelId, nextOwnerId); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return store.GetGroupChannelById(channelId)
+}
@@ -20,8 +20,25 @@ func (presence *Presence) Reset() {
 	presence.Status = "offline"
 }
 
-func UpdateUserPresenceStatus(db *sql.DB, id uuid.UUID, inPresence Presence) (*Presence, error) {
-	user, err := GetUserById(db, id)
+func (store *Store) GetUserPresence(userId uuid.UUID) (*Presence, error) {
+	presence := Presence{}
+
+	presence.Reset()
+
+	err := store.db.QueryRow(
+		"SELECT p.user_id, p.status, p.space_id, p.server_id FROM presence p WHERE p.user_id = $1",
+		userId,
+	).Scan(&presence.UserId, &presence.Status, &presence.SpaceId, &presence.ServerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &presence, nil
+}
+
+func (store *Store) UpdateUserPresenceStatus(id uuid.UUID, inPresence Presence) (*Presence, error) {
+	user, err := store.GetUserById(id)
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +48,7 @@ func UpdateUserPresenceStatus(db *sql.DB, id uuid.UUID, inPresence Presence) (*P
 	presence.Reset()
 
 	// try to find existing record
-	err = db.QueryRow(
+	err = store.db.QueryRow(
 		"SELECT p.user_id, p.status, p.space_id, p.server_id FROM presence p WHERE p.user_id = $1",
 		user.Id,
 	).Scan(&presence.UserId, &presence.Status, &presence.SpaceId, &presence.ServerId)
@@ -50,7 +67,7 @@ func UpdateUserPresenceStatus(db *sql.DB, id uuid.UUID, inPresence Presence) (*P
 		return nil, err
 	} else if err == sql.ErrNoRows {
 		// insert a new presence record for the user
-		rows, err := db.Query(
+		rows, err := store.db.Query(
 			"INSERT INTO presence (user_id, status, space_id, server_id) VALUES ($1, $2, $3, $4)",
 			user.Id,
 			inPresence.Status,
@@ -69,7 +86,7 @@ func UpdateUserPresenceStatus(db *sql.DB, id uuid.UUID, inPresence Presence) (*P
 
 	} else {
 		// update an existing presence record for the user
-		rows, err := db.Query(
+		rows, err := store.db.Query(
 			"UPDATE presence SET status=$2, space_id=$3, server_id=$4 WHERE user_id=$1",
 			user.Id,
 			inPresence.Status,
@@ -89,7 +106,7 @@ func UpdateUserPresenceStatus(db *sql.DB, id uuid.UUID, inPresence Presence) (*P
 
 	presence.Reset()
 
-	err = db.QueryRow(
+	err = store.db.QueryRow(
 		"SELECT p.user_id, p.status, p.space_id, p.server_id FROM presence p WHERE p.user_id = $1",
 		user.Id,
 	).Scan(&presence.UserId, &presence.Status, &presence.SpaceId, &presence.ServerId)
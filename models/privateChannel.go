@@ -0,0 +1,127 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Private channel of two users.
+type PrivateChannel struct {
+	Id      uuid.UUID `json:"id"`
+	HostId  uuid.UUID `json:"hostId"`
+	GuestId uuid.UUID `json:"guestId"`
+	// Messages sent before the time have been delivered to the member the channel has been read for. Delivery of the
+	// later messages is recorded per message.
+	DeliveredAt time.Time `json:"deliveredAt"`
+}
+
+// Stores the channel with its members unless it has been stored before.
+func (store *Store) AddPrivateChannel(channel PrivateChannel) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(
+		"INSERT INTO private_channels (id, host_id, guest_id) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING",
+		channel.Id,
+		channel.HostId,
+		channel.GuestId,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO private_channel_members (channel_id, user_id) VALUES ($1, $2), ($1, $3) ON CONFLICT (channel_id, user_id) DO NOTHING",
+		channel.Id,
+		channel.HostId,
+		channel.GuestId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Returns the private channels of the user with the time the delivery of the messages is recorded since.
+func (store *Store) GetPrivateChannelsByUserId(userId uuid.UUID) ([]PrivateChannel, error) {
+	rows, err := store.db.Query(
+		"SELECT c.id, c.host_id, c.guest_id, m.delivered_at FROM private_channel_members AS m JOIN private_channels AS c ON c.id = m.channel_id WHERE m.user_id = $1",
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := make([]PrivateChannel, 0)
+	for rows.Next() {
+		channel := PrivateChannel{}
+		if err = rows.Scan(&channel.Id, &channel.HostId, &channel.GuestId, &channel.DeliveredAt); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+
+	return channels, rows.Err()
+}
+
+// Messages of the private channel sent after the delivery time of the member and not delivered to the member yet.
+// Takes the user id, the channel id and the channel id of the chat messages.
+const undeliveredPrivateMessagesQuery = `SELECT m.id, pm.user_id FROM private_channel_members AS pm
+JOIN chat_messages AS m ON m.channel_id = $3 AND m.created_at > pm.delivered_at
+WHERE pm.user_id = $1 AND pm.channel_id = $2
+AND NOT EXISTS (SELECT 1 FROM private_channel_deliveries AS d WHERE d.message_id = m.id AND d.user_id = pm.user_id)`
+
+// Records the private channel message delivered to the user. The message is not pushed with the backlog afterwards.
+func (store *Store) AddPrivateMessageDelivery(messageId string, userId uuid.UUID) error {
+	_, err := store.db.Exec(
+		"INSERT INTO private_channel_deliveries (message_id, user_id) VALUES ($1, $2) ON CONFLICT (message_id, user_id) DO NOTHING",
+		messageId,
+		userId,
+	)
+	return err
+}
+
+// Records the messages of the private channel not delivered to the user yet as delivered.
+func (store *Store) AddPrivateChannelDelivered(channelId uuid.UUID, userId uuid.UUID) error {
+	_, err := store.db.Exec(
+		"INSERT INTO private_channel_deliveries (message_id, user_id) "+undeliveredPrivateMessagesQuery+" ON CONFLICT (message_id, user_id) DO NOTHING",
+		userId,
+		channelId,
+		channelId.String(),
+	)
+	return err
+}
+
+// Records the latest messages of the private channel not delivered to the user yet as delivered and returns them in
+// the chronological order. The messages claimed concurrently, e.g. by the other server instance, are not returned.
+func (store *Store) ClaimPrivateChannelBacklog(channelId uuid.UUID, userId uuid.UUID, limit int) ([]ChatMessage, error) {
+	rows, err := store.db.Query(
+		"WITH claimed AS (INSERT INTO private_channel_deliveries (message_id, user_id) "+undeliveredPrivateMessagesQuery+
+			" ORDER BY m.created_at DESC, m.id DESC LIMIT $4 ON CONFLICT (message_id, user_id) DO NOTHING RETURNING message_id)"+
+			" SELECT "+chatMessageColumns+" FROM chat_messages AS m JOIN claimed AS c ON c.message_id = m.id ORDER BY m.created_at, m.id",
+		userId,
+		channelId,
+		channelId.String(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]ChatMessage, 0, limit)
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *m)
+	}
+
+	return messages, rows.Err()
+}
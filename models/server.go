@@ -1,7 +1,6 @@
 package models
 
 import (
-	"database/sql"
 	"github.com/google/uuid"
 )
 
@@ -13,10 +12,11 @@ type Server struct {
 	Public  bool      `json:"public"`
 }
 
-var cachedServers = make([]Server, 0)
+func (store *Store) GetCachedServerById(id uuid.UUID) *Server {
+	store.cachedServersLock.RLock()
+	defer store.cachedServersLock.RUnlock()
 
-func GetCachedServerById(id uuid.UUID) *Server {
-	for _, v := range cachedServers {
+	for _, v := range store.cachedServers {
 		if v.Id == id {
 			return &v
 		}
@@ -24,10 +24,10 @@ func GetCachedServerById(id uuid.UUID) *Server {
 	return nil
 }
 
-func GetServerById(db *sql.DB, serverId uuid.UUID) (*Server, error) {
+func (store *Store) GetServerById(serverId uuid.UUID) (*Server, error) {
 
 	//region cache
-	cachedServer := GetCachedServerById(serverId)
+	cachedServer := store.GetCachedServerById(serverId)
 	if cachedServer != nil {
 		return cachedServer, nil
 	}
@@ -35,7 +35,7 @@ func GetServerById(db *sql.DB, serverId uuid.UUID) (*Server, error) {
 
 	server := Server{}
 
-	err := db.QueryRow(
+	err := store.db.QueryRow(
 		"SELECT s.id, s.host, s.port, s.space_id, s.public FROM servers AS s WHERE s.id = $1",
 		serverId,
 	).Scan(&server.Id, &server.Host, &server.Port, &server.SpaceId, &server.Public)
@@ -44,7 +44,9 @@ func GetServerById(db *sql.DB, serverId uuid.UUID) (*Server, error) {
 		return nil, err
 	}
 
-	cachedServers = append(cachedServers, server) // add to the cache
+	store.cachedServersLock.Lock()
+	store.cachedServers = append(store.cachedServers, server) // add to the cache
+	store.cachedServersLock.Unlock()
 
 	return &server, nil
 }
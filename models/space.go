@@ -1,7 +1,6 @@
 package models
 
 import (
-	"database/sql"
 	"github.com/google/uuid"
 )
 
@@ -12,10 +11,11 @@ type Space struct {
 	ModId uuid.UUID `json:"modId,omitempty"`
 }
 
-var cachedSpaces = make([]Space, 0)
+func (store *Store) GetCachedSpaceById(id uuid.UUID) *Space {
+	store.cachedSpacesLock.RLock()
+	defer store.cachedSpacesLock.RUnlock()
 
-func GetCachedSpaceById(id uuid.UUID) *Space {
-	for _, v := range cachedSpaces {
+	for _, v := range store.cachedSpaces {
 		if v.Id == id {
 			return &v
 		}
@@ -23,10 +23,10 @@ func GetCachedSpaceById(id uuid.UUID) *Space {
 	return nil
 }
 
-func GetSpaceById(db *sql.DB, spaceId uuid.UUID) (*Space, error) {
+func (store *Store) GetSpaceById(spaceId uuid.UUID) (*Space, error) {
 
 	//region cache
-	cachedSpace := GetCachedSpaceById(spaceId)
+	cachedSpace := store.GetCachedSpaceById(spaceId)
 	if cachedSpace != nil {
 		return cachedSpace, nil
 	}
@@ -34,7 +34,7 @@ func GetSpaceById(db *sql.DB, spaceId uuid.UUID) (*Space, error) {
 
 	space := Space{}
 
-	err := db.QueryRow(
+	err := store.db.QueryRow(
 		"SELECT e.id, s.name, s.map, s.mod_id FROM spaces AS s LEFT JOIN entities AS e ON s.id=e.id WHERE s.id = $1",
 		spaceId,
 	).Scan(&space.Id, &space.Name, &space.Map, &space.ModId)
@@ -43,7 +43,9 @@ func GetSpaceById(db *sql.DB, spaceId uuid.UUID) (*Space, error) {
 		return nil, err
 	}
 
-	cachedSpaces = append(cachedSpaces, space)
+	store.cachedSpacesLock.Lock()
+	store.cachedSpaces = append(store.cachedSpaces, space)
+	store.cachedSpacesLock.Unlock()
 
 	return &space, nil
 }
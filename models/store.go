@@ -0,0 +1,35 @@
+package models
+
+import (
+	"database/sql"
+	"github.com/google/uuid"
+	"sync"
+)
+
+// Database with the caches of the users, spaces and servers. Each server owns its store, so the caches are not
+// shared between the servers running in the same process.
+type Store struct {
+	db *sql.DB
+
+	cachedUsers     []User
+	cachedUsersLock sync.RWMutex
+
+	cachedLeaderMap     map[uuid.UUID][]User
+	cachedLeaderMapLock sync.RWMutex
+
+	cachedSpaces     []Space
+	cachedSpacesLock sync.RWMutex
+
+	cachedServers     []Server
+	cachedServersLock sync.RWMutex
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db:              db,
+		cachedUsers:     make([]User, 0),
+		cachedLeaderMap: make(map[uuid.UUID][]User),
+		cachedSpaces:    make([]Space, 0),
+		cachedServers:   make([]Server, 0),
+	}
+}
@@ -12,14 +12,12 @@ type User struct {
 	Presence Presence  `json:"-"`
 }
 
-var cachedUsers = make([]User, 0)
-
-func (user *User) UpdateUserPresence(db *sql.DB, status string, spaceId uuid.UUID, serverId uuid.UUID) (err error) {
+func (user *User) UpdateUserPresence(store *Store, status string, spaceId uuid.UUID, serverId uuid.UUID) (err error) {
 	user.Presence.Status = status
 	user.Presence.SpaceId = spaceId
 	user.Presence.ServerId = serverId
 
-	presence, err := UpdateUserPresenceStatus(db, user.Id, user.Presence)
+	presence, err := store.UpdateUserPresenceStatus(user.Id, user.Presence)
 	if err != nil {
 		return err
 	}
@@ -33,8 +31,11 @@ func (user *User) UpdateUserPresence(db *sql.DB, status string, spaceId uuid.UUI
 	return nil
 }
 
-func GetCachedUserById(id uuid.UUID) *User {
-	for _, v := range cachedUsers {
+func (store *Store) GetCachedUserById(id uuid.UUID) *User {
+	store.cachedUsersLock.RLock()
+	defer store.cachedUsersLock.RUnlock()
+
+	for _, v := range store.cachedUsers {
 		if v.Id == id {
 			return &v
 		}
@@ -42,9 +43,9 @@ func GetCachedUserById(id uuid.UUID) *User {
 	return nil
 }
 
-func GetUserById(db *sql.DB, id uuid.UUID) (*User, error) {
+func (store *Store) GetUserById(id uuid.UUID) (*User, error) {
 	//region cache
-	cachedUser := GetCachedUserById(id)
+	cachedUser := store.GetCachedUserById(id)
 	if cachedUser != nil {
 		return cachedUser, nil
 	}
@@ -53,7 +54,7 @@ func GetUserById(db *sql.DB, id uuid.UUID) (*User, error) {
 	//region database
 	user := User{}
 
-	err := db.QueryRow(
+	err := store.db.QueryRow(
 		"SELECT e.id, u.name FROM users AS u LEFT JOIN entities AS e ON u.id=e.id where u.id = $1",
 		id,
 	).Scan(&user.Id, &user.Name)
@@ -63,15 +64,18 @@ func GetUserById(db *sql.DB, id uuid.UUID) (*User, error) {
 	}
 	//endregion database
 
-	cachedUsers = append(cachedUsers, user) // add to the cache
+	store.cachedUsersLock.Lock()
+	store.cachedUsers = append(store.cachedUsers, user) // add to the cache
+	store.cachedUsersLock.Unlock()
 
 	return &user, nil
 }
 
-var cachedLeaderMap = make(map[uuid.UUID][]User)
+func (store *Store) GetCachedLeadersByUserId(userId uuid.UUID) []User {
+	store.cachedLeaderMapLock.RLock()
+	defer store.cachedLeaderMapLock.RUnlock()
 
-func GetCachedLeadersByUserId(userId uuid.UUID) []User {
-	for k, v := range cachedLeaderMap {
+	for k, v := range store.cachedLeaderMap {
 		if k == userId {
 			return v
 		}
@@ -79,17 +83,17 @@ func GetCachedLeadersByUserId(userId uuid.UUID) []User {
 	return nil
 }
 
-func GetUserLeadersById(db *sql.DB, userId uuid.UUID) ([]User, error) {
+func (store *Store) GetUserLeadersById(userId uuid.UUID) ([]User, error) {
 
 	//region cache
-	cachedLeaders := GetCachedLeadersByUserId(userId)
+	cachedLeaders := store.GetCachedLeadersByUserId(userId)
 	if cachedLeaders != nil {
 		return cachedLeaders, nil
 	}
 	//endregion cache
 
 	//region database
-	rows, err := db.Query(
+	rows, err := store.db.Query(
 		`SELECT f.leader_id AS id, u.name AS name 
 FROM followers AS f
 LEFT JOIN users AS u ON f.leader_id = u.id
@@ -117,7 +121,9 @@ WHERE (f.follower_id = $1) `, //AND f.leader_id != f.follower_id
 	}
 	//endregion database
 
-	cachedLeaderMap[userId] = leaders
+	store.cachedLeaderMapLock.Lock()
+	store.cachedLeaderMap[userId] = leaders
+	store.cachedLeaderMapLock.Unlock()
 
 	return leaders, nil
 }
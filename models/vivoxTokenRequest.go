@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode"
 )
@@ -81,28 +82,49 @@ const defaultAudioFadeModel = 1               // 1, 2, 3
 
 const defaultTokenExpirationTimespan = 60 * time.Second
 
-// region Client Login Token
-var loginTokenSerial int64 = 1
+// Issues the Vivox tokens. Serials are sequenced per client, so each server owns its client.
+type VivoxClient struct {
+	loginTokenSerial  int64
+	joinTokenSerial   int64
+	muteTokenSerial   int64
+	unmuteTokenSerial int64
+	kickTokenSerial   int64
+}
+
+func NewVivoxClient() *VivoxClient {
+	return &VivoxClient{
+		loginTokenSerial:  1,
+		joinTokenSerial:   1,
+		muteTokenSerial:   1,
+		unmuteTokenSerial: 1,
+		kickTokenSerial:   1,
+	}
+}
+
+// Takes the next token serial, safe for concurrent use.
+func nextTokenSerial(serial *int64) int64 {
+	return atomic.AddInt64(serial, 1) - 1
+}
 
-func GetLoginToken(payload VivoxTokenPayload) (string, error) {
+// region Client Login Token
+func (vivox *VivoxClient) GetLoginToken(payload VivoxTokenPayload) (string, error) {
 	expiresAt := time.Now().Add(defaultTokenExpirationTimespan).Unix()
+	serial := nextTokenSerial(&vivox.loginTokenSerial)
 
 	payload.From = GetUserUri(payload.From)
-	payload.Id = loginTokenSerial
+	payload.Id = serial
 	payload.ExpiresAt = expiresAt
 	payload.Action = vivoxActionLogin
 	payload.Issuer = vivoxIssuer
 	payload.Server = vivoxServer
 
-	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionLogin, loginTokenSerial, "", payload.From, "", vivoxSecret)
+	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionLogin, serial, "", payload.From, "", vivoxSecret)
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
 	payload.Token = token
 
-	loginTokenSerial++
-
 	jsonPayload, err := json.Marshal(payload)
 
 	return string(jsonPayload), nil
@@ -111,27 +133,25 @@ func GetLoginToken(payload VivoxTokenPayload) (string, error) {
 //endregion
 
 // region Client Join Token
-var joinTokenSerial int64 = 1
-
-func GetJoinToken(payload VivoxTokenPayload) (string, error) {
+func (vivox *VivoxClient) GetJoinToken(payload VivoxTokenPayload) (string, error) {
 	expiresAt := time.Now().Add(defaultTokenExpirationTimespan).Unix()
+	serial := nextTokenSerial(&vivox.joinTokenSerial)
 
 	payload.From = GetUserUri(payload.From)
 	payload.To = GetChannelUri(&payload.ChannelProperties)
-	payload.Id = joinTokenSerial
+	payload.Id = serial
 	payload.ExpiresAt = expiresAt
 	payload.Action = vivoxActionJoin
 	payload.Issuer = vivoxIssuer
 	payload.Server = vivoxServer
 
-	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionJoin, joinTokenSerial, "", payload.From, payload.To, vivoxSecret)
+	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionJoin, serial, "", payload.From, payload.To, vivoxSecret)
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
 	payload.Token = token
 
-	joinTokenSerial++
 	jsonPayload, err := json.Marshal(payload)
 	return string(jsonPayload), nil
 }
@@ -215,32 +235,29 @@ func RequestLogin() (string, error) {
 //endregion
 
 // region Server-to-Server Mute
-var muteTokenSerial int64 = 1
-
-func RequestMute(payload VivoxTokenPayload) (string, error) {
+func (vivox *VivoxClient) RequestMute(payload VivoxTokenPayload) (string, error) {
 	authToken, err := RequestLogin()
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
 	expiresAt := time.Now().Add(defaultTokenExpirationTimespan).Unix()
+	serial := nextTokenSerial(&vivox.muteTokenSerial)
 
 	payload.From = GetUserUri(payload.From)
 	payload.To = GetChannelUri(&payload.ChannelProperties)
 	payload.Subject = GetUserUri(payload.Subject)
-	payload.Id = muteTokenSerial
+	payload.Id = serial
 	payload.ExpiresAt = expiresAt
 	payload.Action = vivoxActionMute
 	payload.Issuer = vivoxIssuer
 	payload.Server = vivoxServer
 
-	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionMute, muteTokenSerial, payload.Subject, payload.From, payload.To, vivoxSecret)
+	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionMute, serial, payload.Subject, payload.From, payload.To, vivoxSecret)
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
-	muteTokenSerial++
-
 	payload.Token = token
 
 	apiUrl := vivoxServer
@@ -286,32 +303,29 @@ func RequestMute(payload VivoxTokenPayload) (string, error) {
 	}
 }
 
-var unmuteTokenSerial int64 = 1
-
-func RequestUnmute(payload VivoxTokenPayload) (string, error) {
+func (vivox *VivoxClient) RequestUnmute(payload VivoxTokenPayload) (string, error) {
 	authToken, err := RequestLogin()
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
 	expiresAt := time.Now().Add(defaultTokenExpirationTimespan).Unix()
+	serial := nextTokenSerial(&vivox.unmuteTokenSerial)
 
 	payload.From = GetUserUri(payload.From)
 	payload.To = GetChannelUri(&payload.ChannelProperties)
 	payload.Subject = GetUserUri(payload.Subject)
-	payload.Id = muteTokenSerial
+	payload.Id = serial
 	payload.ExpiresAt = expiresAt
 	payload.Action = vivoxActionUnmute
 	payload.Issuer = vivoxIssuer
 	payload.Server = vivoxServer
 
-	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionUnmute, unmuteTokenSerial, payload.Subject, payload.From, payload.To, vivoxSecret)
+	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionUnmute, serial, payload.Subject, payload.From, payload.To, vivoxSecret)
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
-	unmuteTokenSerial++
-
 	payload.Token = token
 
 	apiUrl := vivoxServer
@@ -360,32 +374,29 @@ func RequestUnmute(payload VivoxTokenPayload) (string, error) {
 //endregion
 
 // region Server-to-Server Kick
-var kickTokenSerial int64 = 1
-
-func RequestKick(payload VivoxTokenPayload) (string, error) {
+func (vivox *VivoxClient) RequestKick(payload VivoxTokenPayload) (string, error) {
 	authToken, err := RequestLogin()
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
 	expiresAt := time.Now().Add(defaultTokenExpirationTimespan).Unix()
+	serial := nextTokenSerial(&vivox.kickTokenSerial)
 
 	payload.From = GetUserUri(payload.From)
 	payload.To = GetChannelUri(&payload.ChannelProperties)
 	payload.Subject = GetUserUri(payload.Subject)
-	payload.Id = kickTokenSerial
+	payload.Id = serial
 	payload.ExpiresAt = expiresAt
 	payload.Action = vivoxActionKick
 	payload.Issuer = vivoxIssuer
 	payload.Server = vivoxServer
 
-	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionKick, kickTokenSerial, payload.Subject, payload.From, payload.To, vivoxSecret)
+	token, err := GenerateVivoxToken(vivoxIssuer, expiresAt, vivoxActionKick, serial, payload.Subject, payload.From, payload.To, vivoxSecret)
 	if err != nil {
 		return `{"status":"error"}`, err
 	}
 
-	kickTokenSerial++
-
 	payload.Token = token
 
 	apiUrl := vivoxServer
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	config "github.com/spf13/viper"
+)
+
+const (
+	// Role allowing to edit and delete the chat messages of the other users and to mute, unmute and kick the Vivox users.
+	RoleModerator = "moderator"
+	// Role allowing to read the websocket server stats, also allowed to the moderators.
+	RoleAdmin = "admin"
+)
+
+// Authenticated identity of the websocket client.
+type AuthIdentity struct {
+	// Id of the authenticated user.
+	UserId uuid.UUID
+	// Roles granted to the user by the token issuer.
+	Roles []string
+	// Time of the token expiration.
+	ExpiresAt time.Time
+}
+
+// Checks if the identity has been granted the role.
+func (identity *AuthIdentity) HasRole(role string) bool {
+	for _, r := range identity.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the session token has expired. Identities without the expiration time never expire.
+func (identity *AuthIdentity) IsExpired(now time.Time) bool {
+	return !identity.ExpiresAt.IsZero() && !now.Before(identity.ExpiresAt)
+}
+
+// Verifies session tokens presented by the clients during the connect handshake.
+type Authenticator interface {
+	Authenticate(token string) (*AuthIdentity, error)
+}
+
+var (
+	errAuthTokenMissing = errors.New("session token is missing")
+	errAuthTokenInvalid = errors.New("session token is invalid")
+	errAuthTokenExpired = errors.New("session token has expired")
+)
+
+// Claims of the session tokens issued by the API.
+type jwtSessionClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Authenticator verifying HMAC signed JWT session tokens issued by the API.
+type JwtAuthenticator struct {
+	secret   []byte
+	issuer   string
+	audience string
+}
+
+func NewJwtAuthenticator(secret string, issuer string, audience string) *JwtAuthenticator {
+	return &JwtAuthenticator{
+		secret:   []byte(secret),
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// Creates the JWT authenticator using the "auth.jwt" configuration section.
+func newJwtAuthenticatorFromConfig() *JwtAuthenticator {
+	return NewJwtAuthenticator(
+		config.GetString("auth.jwt.secret"),
+		config.GetString("auth.jwt.issuer"),
+		config.GetString("auth.jwt.audience"),
+	)
+}
+
+func (authenticator *JwtAuthenticator) Authenticate(token string) (*AuthIdentity, error) {
+	if token == "" {
+		return nil, errAuthTokenMissing
+	}
+
+	if len(authenticator.secret) == 0 {
+		return nil, fmt.Errorf("%w: authenticator secret is not configured", errAuthTokenInvalid)
+	}
+
+	claims := jwtSessionClaims{}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{
+		jwt.SigningMethodHS256.Alg(),
+		jwt.SigningMethodHS384.Alg(),
+		jwt.SigningMethodHS512.Alg(),
+	}))
+
+	_, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return authenticator.secret, nil
+	})
+
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired {
+			return nil, errAuthTokenExpired
+		}
+		return nil, fmt.Errorf("%w: %s", errAuthTokenInvalid, err.Error())
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: token has no expiration", errAuthTokenInvalid)
+	}
+
+	if authenticator.issuer != "" && !claims.VerifyIssuer(authenticator.issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer %s", errAuthTokenInvalid, claims.Issuer)
+	}
+
+	if authenticator.audience != "" && !claims.VerifyAudience(authenticator.audience, true) {
+		return nil, fmt.Errorf("%w: unexpected audience %v", errAuthTokenInvalid, claims.Audience)
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: subject is not a user id", errAuthTokenInvalid)
+	}
+
+	return &AuthIdentity{
+		UserId:    userId,
+		Roles:     claims.Roles,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
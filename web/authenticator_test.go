@@ -0,0 +1,149 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+func TestJwtAuthenticatorAuthenticate(t *testing.T) {
+	const (
+		secret   = "secret"
+		issuer   = "issuer"
+		audience = "audience"
+	)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the rsa key: %s", err.Error())
+	}
+
+	userId := uuid.New()
+	now := time.Now()
+
+	newClaims := func() jwtSessionClaims {
+		return jwtSessionClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   userId.String(),
+				Issuer:    issuer,
+				Audience:  jwt.ClaimStrings{audience},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+			Roles: []string{RoleModerator},
+		}
+	}
+
+	sign := func(method jwt.SigningMethod, key interface{}, change func(claims *jwtSessionClaims)) string {
+		claims := newClaims()
+		if change != nil {
+			change(&claims)
+		}
+
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign the token: %s", err.Error())
+		}
+		return token
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		err    error
+	}{
+		{name: "HS256", secret: secret, token: sign(jwt.SigningMethodHS256, []byte(secret), nil)},
+		{name: "HS384", secret: secret, token: sign(jwt.SigningMethodHS384, []byte(secret), nil)},
+		{name: "HS512", secret: secret, token: sign(jwt.SigningMethodHS512, []byte(secret), nil)},
+		{name: "missing token", secret: secret, token: "", err: errAuthTokenMissing},
+		{name: "empty secret", secret: "", token: sign(jwt.SigningMethodHS256, []byte(""), nil), err: errAuthTokenInvalid},
+		{name: "wrong secret", secret: secret, token: sign(jwt.SigningMethodHS256, []byte("other"), nil), err: errAuthTokenInvalid},
+		{name: "none alg", secret: secret, token: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil), err: errAuthTokenInvalid},
+		{name: "RS256 alg", secret: secret, token: sign(jwt.SigningMethodRS256, rsaKey, nil), err: errAuthTokenInvalid},
+		{name: "malformed token", secret: secret, token: "token", err: errAuthTokenInvalid},
+		{
+			name:   "missing exp",
+			secret: secret,
+			token:  sign(jwt.SigningMethodHS256, []byte(secret), func(claims *jwtSessionClaims) { claims.ExpiresAt = nil }),
+			err:    errAuthTokenInvalid,
+		},
+		{
+			name:   "expired",
+			secret: secret,
+			token: sign(jwt.SigningMethodHS256, []byte(secret), func(claims *jwtSessionClaims) {
+				claims.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+			}),
+			err: errAuthTokenExpired,
+		},
+		{
+			name:   "expired with wrong secret",
+			secret: secret,
+			token: sign(jwt.SigningMethodHS256, []byte("other"), func(claims *jwtSessionClaims) {
+				claims.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+			}),
+			err: errAuthTokenInvalid,
+		},
+		{
+			name:   "wrong issuer",
+			secret: secret,
+			token:  sign(jwt.SigningMethodHS256, []byte(secret), func(claims *jwtSessionClaims) { claims.Issuer = "other" }),
+			err:    errAuthTokenInvalid,
+		},
+		{
+			name:   "missing issuer",
+			secret: secret,
+			token:  sign(jwt.SigningMethodHS256, []byte(secret), func(claims *jwtSessionClaims) { claims.Issuer = "" }),
+			err:    errAuthTokenInvalid,
+		},
+		{
+			name:   "wrong audience",
+			secret: secret,
+			token: sign(jwt.SigningMethodHS256, []byte(secret), func(claims *jwtSessionClaims) {
+				claims.Audience = jwt.ClaimStrings{"other"}
+			}),
+			err: errAuthTokenInvalid,
+		},
+		{
+			name:   "missing audience",
+			secret: secret,
+			token:  sign(jwt.SigningMethodHS256, []byte(secret), func(claims *jwtSessionClaims) { claims.Audience = nil }),
+			err:    errAuthTokenInvalid,
+		},
+		{
+			name:   "subject is not a user id",
+			secret: secret,
+			token:  sign(jwt.SigningMethodHS256, []byte(secret), func(claims *jwtSessionClaims) { claims.Subject = "user" }),
+			err:    errAuthTokenInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator := NewJwtAuthenticator(test.secret, issuer, audience)
+
+			identity, err := authenticator.Authenticate(test.token)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got error {%v}, expected {%v}", err, test.err)
+				}
+				if identity != nil {
+					t.Errorf("got identity of the rejected token")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to authenticate: %s", err.Error())
+			}
+			if identity.UserId != userId || !identity.HasRole(RoleModerator) || !identity.ExpiresAt.Equal(now.Add(time.Hour).Truncate(time.Second)) {
+				t.Errorf("got identity {%+v}", identity)
+			}
+		})
+	}
+}
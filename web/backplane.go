@@ -0,0 +1,151 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	config "github.com/spf13/viper"
+)
+
+// Backplane drivers.
+const (
+	BackplaneDriverMemory   string = "memory"
+	BackplaneDriverPostgres string = "postgres"
+)
+
+type BackplaneEventKind string
+
+const (
+	BackplaneBroadcast      BackplaneEventKind = "broadcast"      // Chat message or notification sent to all the channel subscribers.
+	BackplaneMulticast      BackplaneEventKind = "multicast"      // Presence change sent to the listed channel subscribers.
+	BackplanePrivateChannel BackplaneEventKind = "privateChannel" // Private channel opened by the host with the guest.
+	BackplaneGroupRemoved   BackplaneEventKind = "groupRemoved"   // Member removed from the group channel.
+)
+
+// Event published to all the server instances.
+type BackplaneEvent struct {
+	Kind      BackplaneEventKind `json:"kind"`
+	Origin    uuid.UUID          `json:"origin"` // Instance which has published the event.
+	ChannelId uuid.UUID          `json:"channelId"`
+	UserIds   []uuid.UUID        `json:"userIds,omitempty"` // Multicast recipients, the host and the guest of the private channel, or the removed group member.
+	Payload   *WebsocketPayload  `json:"payload,omitempty"`
+}
+
+type BackplaneHandler func(event *BackplaneEvent)
+
+// Delivers the events to all the server instances, so the clients connected to different instances can talk to each other.
+type Backplane interface {
+	// Publishes the event to all the subscribed instances, including the publishing one.
+	Publish(event *BackplaneEvent) error
+	// Starts delivering the published events to the handler.
+	Subscribe(handler BackplaneHandler) error
+	Close() error
+}
+
+// Creates the backplane configured by "rpc.backplane.driver". The postgres backplane listens with a dedicated
+// connection to the database url.
+func newBackplaneFromConfig(db *sql.DB, dbUrl string) (Backplane, error) {
+	switch driver := config.GetString("rpc.backplane.driver"); driver {
+	case BackplaneDriverMemory, "":
+		return NewMemoryBackplane(), nil
+	case BackplaneDriverPostgres:
+		return NewPostgresBackplane(db, dbUrl, config.GetString("rpc.backplane.channel"))
+	default:
+		return nil, fmt.Errorf("unknown backplane driver: %s", driver)
+	}
+}
+
+//region memory
+
+// Delivers the events within the process. Used to run a single instance or several instances in one process.
+type MemoryBackplane struct {
+	lock     sync.RWMutex
+	handlers []BackplaneHandler
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{}
+}
+
+func (backplane *MemoryBackplane) Publish(event *BackplaneEvent) error {
+	backplane.lock.RLock()
+	handlers := backplane.handlers
+	backplane.lock.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+
+	return nil
+}
+
+func (backplane *MemoryBackplane) Subscribe(handler BackplaneHandler) error {
+	backplane.lock.Lock()
+	backplane.handlers = append(backplane.handlers[:len(backplane.handlers):len(backplane.handlers)], handler)
+	backplane.lock.Unlock()
+	return nil
+}
+
+func (backplane *MemoryBackplane) Close() error {
+	backplane.lock.Lock()
+	backplane.handlers = nil
+	backplane.lock.Unlock()
+	return nil
+}
+
+//endregion memory
+
+// Publishes the event on behalf of this instance.
+func (server *WebsocketServer) publish(event *BackplaneEvent) {
+	event.Origin = server.instanceId
+
+	if err := server.backplane.Publish(event); err != nil {
+		log.Errorf("got an error publishing backplane event, kind: {%s}, channel: {%s}: %s", event.Kind, event.ChannelId, err.Error())
+	}
+}
+
+// Replaces the backplane and subscribes for its events.
+func (server *WebsocketServer) useBackplane(backplane Backplane) error {
+	if err := backplane.Subscribe(server.onBackplaneEvent); err != nil {
+		return err
+	}
+
+	if server.backplane != nil {
+		_ = server.backplane.Close()
+	}
+
+	server.backplane = backplane
+
+	return nil
+}
+
+// Delivers the events published by the other instances to the clients connected to this instance.
+func (server *WebsocketServer) onBackplaneEvent(event *BackplaneEvent) {
+	if event.Origin == server.instanceId {
+		return
+	}
+
+	switch event.Kind {
+	case BackplaneBroadcast:
+		if event.Payload != nil {
+			server.broadcastMessageToLocalChannel(event.ChannelId, *event.Payload)
+		}
+	case BackplaneMulticast:
+		if event.Payload != nil {
+			server.multicastMessageToLocalChannel(event.UserIds, event.ChannelId, *event.Payload)
+		}
+	case BackplanePrivateChannel:
+		if len(event.UserIds) == 2 {
+			server.joinPrivateChannel(event.ChannelId, event.UserIds[0], event.UserIds[1])
+		}
+	case BackplaneGroupRemoved:
+		for _, userId := range event.UserIds {
+			server.removeLocalGroupMember(event.ChannelId, userId)
+		}
+	default:
+		log.Warnf("ignoring unknown backplane event, kind: {%s}", event.Kind)
+	}
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Postgres rejects notifications with payloads of 8000 bytes and more. The chat messages are limited to 6144 bytes
+	// by the maxBytes arg rule, leaving the rest for the event envelope and the sender.
+	maxNotifyPayloadSize = 7999
+
+	listenerMinReconnectInterval = 10 * time.Second
+	listenerMaxReconnectInterval = time.Minute
+	listenerPingInterval         = 90 * time.Second
+)
+
+// Delivers the events to the instances sharing the database via LISTEN/NOTIFY. Events are published with the pool
+// connections, while a dedicated connection listens for the events.
+type PostgresBackplane struct {
+	db       *sql.DB
+	channel  string
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+func NewPostgresBackplane(db *sql.DB, dbUrl string, channel string) (*PostgresBackplane, error) {
+	listener := pq.NewListener(dbUrl, listenerMinReconnectInterval, listenerMaxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Errorf("backplane listener error, event: {%d}: %s", event, err.Error())
+		}
+	})
+
+	if err := listener.Listen(channel); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return &PostgresBackplane{
+		db:       db,
+		channel:  channel,
+		listener: listener,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (backplane *PostgresBackplane) Publish(event *BackplaneEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayloadSize {
+		return fmt.Errorf("backplane event is too large: %d bytes", len(payload))
+	}
+
+	_, err = backplane.db.Exec("SELECT pg_notify($1, $2)", backplane.channel, string(payload))
+
+	return err
+}
+
+func (backplane *PostgresBackplane) Subscribe(handler BackplaneHandler) error {
+	go backplane.goListen(handler)
+	return nil
+}
+
+func (backplane *PostgresBackplane) goListen(handler BackplaneHandler) {
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case notification, ok := <-backplane.listener.Notify:
+			if !ok {
+				return
+			}
+
+			// Sent after reconnect, the events published while disconnected are lost.
+			if notification == nil {
+				log.Warnf("backplane listener has reconnected")
+				continue
+			}
+
+			var event BackplaneEvent
+			if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+				log.Errorf("got an invalid backplane event: %s", err.Error())
+				continue
+			}
+
+			handler(&event)
+		case <-ticker.C:
+			// Detect broken connections while idle.
+			go func() {
+				_ = backplane.listener.Ping()
+			}()
+		case <-backplane.done:
+			return
+		}
+	}
+}
+
+func (backplane *PostgresBackplane) Close() error {
+	close(backplane.done)
+	return backplane.listener.Close()
+}
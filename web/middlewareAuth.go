@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Allows the request bearing the session token of the user granted any of the roles.
+func (server *WebsocketServer) requireRoles(next http.Handler, roles ...string) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if server.authenticator == nil {
+			http.Error(w, "authenticator is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, errAuthTokenMissing.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		identity, err := server.authenticator.Authenticate(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+		if err != nil {
+			log.Warnf("rejecting request to {%s}: %s", r.URL.Path, err.Error())
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		for _, role := range roles {
+			if identity.HasRole(role) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		http.Error(w, "request is not allowed", http.StatusForbidden)
+	})
+
+}
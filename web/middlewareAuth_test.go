@@ -0,0 +1,67 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Requests the websocket server stats with the session tokens granted the different roles.
+func TestWebsocketServerRequireRoles(t *testing.T) {
+	defer quietTestLog()()
+
+	const secret = "secret"
+
+	server := newTestWebsocketServer()
+	server.authenticator = NewJwtAuthenticator(secret, "", "")
+
+	handler := server.requireRoles(http.HandlerFunc(server.handleStats), RoleAdmin, RoleModerator)
+
+	sign := func(roles ...string) string {
+		claims := jwtSessionClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   uuid.NewString(),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Roles: roles,
+		}
+
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("failed to sign the token: %s", err.Error())
+		}
+		return "Bearer " + token
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "missing", authorization: "", status: http.StatusUnauthorized},
+		{name: "invalid", authorization: "Bearer invalid", status: http.StatusUnauthorized},
+		{name: "user", authorization: sign(), status: http.StatusForbidden},
+		{name: "moderator", authorization: sign(RoleModerator), status: http.StatusOK},
+		{name: "admin", authorization: sign(RoleAdmin), status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/ws/stats", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("got status {%d}, expected {%d}", recorder.Code, test.status)
+			}
+		})
+	}
+}
@@ -1,16 +1,17 @@
 package web
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"time"
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1 << 20,
 	WriteBufferSize: 1 << 20,
+	Subprotocols:    getWebsocketSubprotocols(),
 }
 
 func (s webServer) initWebsocketRoutes() {
@@ -19,44 +20,94 @@ func (s webServer) initWebsocketRoutes() {
 
 	r.Path("").
 		Methods("GET").
-		HandlerFunc(handleWebsocket).
+		Handler(s.websocket).
 		Name("ws")
+
+	// Protocol JSON Schema for the client code generation.
+	r.Path("/schema").
+		Methods("GET").
+		HandlerFunc(handleWebsocketSchema).
+		Name("ws-schema")
+
+	// Counters of the websocket server for monitoring, available to the admins and the moderators.
+	r.Path("/stats").
+		Methods("GET").
+		Handler(s.websocket.requireRoles(http.HandlerFunc(s.websocket.handleStats), RoleAdmin, RoleModerator)).
+		Name("ws-stats")
+}
+
+func handleWebsocketSchema(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(generateProtocolSchema(websocketRpcRegistry.methods)); err != nil {
+		log.Errorf("got an error encoding the protocol schema: %s", err.Error())
+	}
 }
 
-func handleWebsocket(w http.ResponseWriter, r *http.Request) {
+func (server *WebsocketServer) handleStats(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(server.Stats()); err != nil {
+		log.Errorf("got an error encoding the websocket server stats: %s", err.Error())
+	}
+}
+
+// Upgrades the request to the websocket connection and serves the client. Allows embedding the server into
+// other HTTP servers.
+func (server *WebsocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if server.isDraining() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	remoteIp := server.admission.getClientIp(r)
+
+	if err := server.admission.admit(remoteIp); err != nil {
+		log.Warnf("rejecting websocket connection from {%s}: %s", remoteIp, err.Error())
+
+		status := http.StatusServiceUnavailable
+		if err == errTooManyIpConnections {
+			status = http.StatusTooManyRequests
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 
 	if err != nil {
+		server.admission.release(remoteIp)
 		log.Errorf("got an error trying to upgrade to websocket protocol: %s", err.Error())
 		return
 	}
 
 	// Create and register a client.
 	client := &WebsocketClient{Id: uuid.New(),
-		server:   WebsocketServerInstance,
-		conn:     conn,
-		send:     make(chan []byte, 256),
-		handlers: make(map[string]websocketRequestHandler),
-		requests: make(map[uuid.UUID]time.Time),
+		server:        server,
+		conn:          conn,
+		remoteIp:      remoteIp,
+		serializer:    getWebsocketMessageSerializer(conn.Subprotocol()),
+		send:          newOutboundQueue(server.outbound.QueueSize),
+		requests:      make(map[uuid.UUID]*websocketPendingRequest),
+		disconnecting: make(chan bool, 1),
+		rateLimiter:   newClientRateLimiter(),
 	}
 
-	client.registerHandler(SystemTopic, ConnectMethod, connectHandler)
-	client.registerHandler(SystemTopic, PresenceUpdateMethod, presenceUpdateHandler)
-	client.registerHandler(SystemTopic, UserChangeNameMethod, userChangeNameHandler)
-
-	client.registerHandler(ChatTopic, ChannelSendMethod, channelMessageHandler)
-	client.registerHandler(ChatTopic, ChannelSubscribeMethod, channelSubscribeHandler)
-	client.registerHandler(ChatTopic, ChannelUnsubscribeMethod, channelUnsubscribeHandler)
-
-	client.registerHandler(AnalyticsTopic, UserActionMethod, userActionHandler)
-
-	client.registerHandler(VivoxTopic, VivoxGetLoginTokenMethod, vivoxHandler)
-	client.registerHandler(VivoxTopic, VivoxGetJoinTokenMethod, vivoxHandler)
-	client.registerHandler(VivoxTopic, VivoxMuteMethod, vivoxHandler)
-	client.registerHandler(VivoxTopic, VivoxUnmuteMethod, vivoxHandler)
-	client.registerHandler(VivoxTopic, VivoxKickMethod, vivoxHandler)
+	select {
+	case server.register <- client:
+	case <-server.done:
+		server.admission.release(remoteIp)
+		_ = conn.Close()
+		return
+	}
 
-	client.server.register <- client
+	if timeout := server.admission.settings.AuthTimeout; timeout > 0 {
+		client.startAuthenticationDeadline(timeout)
+	}
 
 	go client.goSocketWrite()
 	go client.goSocketRead()
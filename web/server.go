@@ -1,6 +1,8 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -9,19 +11,20 @@ import (
 )
 
 type webServer struct {
-	host   string
-	port   string
-	server *http.Server
-	router *mux.Router
+	host      string
+	port      string
+	server    *http.Server
+	router    *mux.Router
+	websocket *WebsocketServer
 }
 
-func NewWebServer(host string, port string) (s *webServer) {
+func NewWebServer(host string, port string, websocket *WebsocketServer) (s *webServer) {
 
 	// Create a mux router.
 	r := mux.NewRouter()
 
 	// Init webServer wrapper struct.
-	s = &webServer{router: r, host: host, port: port}
+	s = &webServer{router: r, host: host, port: port, websocket: websocket}
 
 	// Error
 	s.initErrorMiddleware()
@@ -35,11 +38,6 @@ func NewWebServer(host string, port string) (s *webServer) {
 	// HTTP routing.
 	s.initRouting()
 
-	return
-}
-
-func (s webServer) Start() {
-
 	// Create HTTP server.
 	s.server = &http.Server{
 		Handler:      s.router,
@@ -48,7 +46,27 @@ func (s webServer) Start() {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	if err := s.server.ListenAndServe(); err != nil {
+	return
+}
+
+// Serves until the server is shut down.
+func (s *webServer) Start() {
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 }
+
+// Stops accepting websocket upgrades, drains the websocket clients and shuts down the HTTP server. Connections still
+// open when the context is done are closed forcibly.
+func (s *webServer) Shutdown(ctx context.Context) error {
+	err := s.websocket.Shutdown(ctx)
+
+	if httpErr := s.server.Shutdown(ctx); httpErr != nil {
+		_ = s.server.Close()
+		if err == nil {
+			err = httpErr
+		}
+	}
+
+	return err
+}
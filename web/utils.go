@@ -1,6 +1,10 @@
 package web
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 func containsUUID(arr []uuid.UUID, channel uuid.UUID) bool {
 	for _, a := range arr {
@@ -10,3 +14,11 @@ func containsUUID(arr []uuid.UUID, channel uuid.UUID) bool {
 	}
 	return false
 }
+
+// Returns unix milliseconds of the time, zero for the zero time.
+func getUnixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	config "github.com/spf13/viper"
+)
+
+var (
+	errTooManyConnections     = errors.New("too many connections")
+	errTooManyIpConnections   = errors.New("too many connections from the address")
+	errTooManyUserConnections = errors.New("too many connections of the user")
+)
+
+// Reasons of the close frames sent to the clients not authenticated in time or with the expired session token.
+const (
+	authenticationTimeoutReason = "authentication timeout"
+	authenticationExpiredReason = "session token expired"
+)
+
+// Connection limits, read from the "rpc.admission" configuration section. Zero values disable the limits.
+type AdmissionConfig struct {
+	// Maximal number of the connections to this instance.
+	MaxConnections int
+	// Maximal number of the connections from a single address to this instance.
+	MaxConnectionsPerIp int
+	// Maximal number of the connections of a single authenticated user to this instance. The connections to the other
+	// instances are not counted, so the user may have this many connections to each of the replicas.
+	MaxConnectionsPerUser int
+	// Connections not completing the connect handshake within this period are closed.
+	AuthTimeout time.Duration
+	// Header set by the reverse proxy to the client address, e.g. "X-Real-IP". The remote address is used if empty.
+	ClientIpHeader string
+}
+
+// Reads the "rpc.admission" configuration section.
+func newAdmissionConfigFromConfig() AdmissionConfig {
+	return AdmissionConfig{
+		MaxConnections:        config.GetInt("rpc.admission.maxConnections"),
+		MaxConnectionsPerIp:   config.GetInt("rpc.admission.maxConnectionsPerIp"),
+		MaxConnectionsPerUser: config.GetInt("rpc.admission.maxConnectionsPerUser"),
+		AuthTimeout:           config.GetDuration("rpc.admission.authTimeout"),
+		ClientIpHeader:        config.GetString("rpc.admission.clientIpHeader"),
+	}
+}
+
+// Counts the connections to admit by the limits.
+type connectionAdmission struct {
+	lock     sync.Mutex
+	settings AdmissionConfig
+	total    int
+	perIp    map[string]int
+}
+
+func newConnectionAdmission(settings AdmissionConfig) *connectionAdmission {
+	return &connectionAdmission{
+		settings: settings,
+		perIp:    make(map[string]int),
+	}
+}
+
+// Returns the client address, taking the last hop appended by the reverse proxy if the header is configured.
+func (admission *connectionAdmission) getClientIp(r *http.Request) string {
+	if header := admission.settings.ClientIpHeader; header != "" {
+		if value := r.Header.Get(header); value != "" {
+			hops := strings.Split(value, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Admits the connection from the address. Each admitted connection must be released.
+func (admission *connectionAdmission) admit(ip string) error {
+	admission.lock.Lock()
+	defer admission.lock.Unlock()
+
+	if max := admission.settings.MaxConnections; max > 0 && admission.total >= max {
+		return errTooManyConnections
+	}
+
+	if max := admission.settings.MaxConnectionsPerIp; max > 0 && admission.perIp[ip] >= max {
+		return errTooManyIpConnections
+	}
+
+	admission.total++
+	admission.perIp[ip]++
+
+	return nil
+}
+
+func (admission *connectionAdmission) release(ip string) {
+	admission.lock.Lock()
+	defer admission.lock.Unlock()
+
+	admission.total--
+	if admission.perIp[ip]--; admission.perIp[ip] <= 0 {
+		delete(admission.perIp, ip)
+	}
+}
+
+// Closes the connection of the client not authenticated within the timeout.
+func (client *WebsocketClient) startAuthenticationDeadline(timeout time.Duration) {
+	time.AfterFunc(timeout, func() {
+		if client.isAuthenticated() || client.send.isClosed() {
+			return
+		}
+
+		client.disconnect(websocket.ClosePolicyViolation, authenticationTimeoutReason)
+	})
+}
+
+// Closes the connection of the client when the session token of the identity expires, unless the client has repeated
+// the connect handshake with a new token meanwhile.
+func (client *WebsocketClient) startAuthenticationExpiry(identity *AuthIdentity) {
+	if identity.ExpiresAt.IsZero() {
+		return
+	}
+
+	time.AfterFunc(time.Until(identity.ExpiresAt), func() {
+		if client.getIdentity() != identity || client.send.isClosed() {
+			return
+		}
+
+		client.disconnect(websocket.ClosePolicyViolation, authenticationExpiredReason)
+	})
+}
@@ -0,0 +1,268 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Validation rules are declared with the "validate" struct tag as a comma separated list:
+//
+//	required  - the arg must be present and must not be null or an empty string
+//	uuid      - the string must be a valid UUID, implied for uuid.UUID fields
+//	min=N     - minimal string length, array length or number value
+//	max=N     - maximal string length, array length or number value
+//	oneof=a|b - the string must be one of the listed values
+//	maxBytes=N - maximal size of the JSON encoded string, so it fits the backplane events
+//
+// The same rules are used to generate the protocol JSON Schema, except maxBytes which the schema can not express.
+const validateTag = "validate"
+
+var uuidType = reflect.TypeOf(uuid.UUID{})
+
+// Error describing the arg that failed validation.
+type WebsocketArgsError struct {
+	Arg  string // Path to the arg, e.g. "presence.status".
+	Rule string // Failed rule.
+	Err  error
+}
+
+func (e *WebsocketArgsError) Error() string {
+	return fmt.Sprintf("invalid arg %s (%s): %s", e.Arg, e.Rule, e.Err.Error())
+}
+
+func (e *WebsocketArgsError) Unwrap() error {
+	return e.Err
+}
+
+// Details reported to the client.
+func (e *WebsocketArgsError) details() map[string]interface{} {
+	return map[string]interface{}{"arg": e.Arg, "rule": e.Rule}
+}
+
+// Validates loosely typed args against the rules declared by the args struct and decodes them into the struct.
+func decodeArgs(args interface{}, out interface{}) error {
+	t := reflect.TypeOf(out).Elem()
+
+	// Missing args are validated as empty ones to report required args.
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+
+	if err := validateArgsValue(t, args, ""); err != nil {
+		return err
+	}
+
+	jsonBody, err := json.Marshal(args)
+	if err != nil {
+		return &WebsocketArgsError{Arg: "args", Rule: "type", Err: err}
+	}
+
+	if err = json.Unmarshal(jsonBody, out); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return &WebsocketArgsError{Arg: typeErr.Field, Rule: "type", Err: fmt.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value)}
+		}
+		return &WebsocketArgsError{Arg: "args", Rule: "type", Err: err}
+	}
+
+	return nil
+}
+
+// Validates the raw arg value against the type and rules of the struct field.
+func validateArgsValue(t reflect.Type, value interface{}, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if value == nil {
+		return nil
+	}
+
+	if t == uuidType {
+		s, ok := value.(string)
+		if !ok {
+			return &WebsocketArgsError{Arg: path, Rule: "uuid", Err: fmt.Errorf("expected uuid string")}
+		}
+		if _, err := uuid.Parse(s); err != nil {
+			return &WebsocketArgsError{Arg: path, Rule: "uuid", Err: err}
+		}
+		return nil
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return &WebsocketArgsError{Arg: argsPath(path, ""), Rule: "type", Err: fmt.Errorf("expected object")}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := getArgName(field)
+		if !ok {
+			continue
+		}
+
+		fieldPath := argsPath(path, name)
+		fieldValue, present := m[name]
+
+		for _, rule := range getArgRules(field) {
+			if err := validateArgRule(rule, fieldValue, present); err != nil {
+				return &WebsocketArgsError{Arg: fieldPath, Rule: rule.name, Err: err}
+			}
+		}
+
+		if err := validateArgsValue(field.Type, fieldValue, fieldPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type argRule struct {
+	name  string
+	param string
+}
+
+func validateArgRule(rule argRule, value interface{}, present bool) error {
+	if rule.name == "required" {
+		if !present || value == nil || value == "" {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	}
+
+	if !present || value == nil {
+		return nil
+	}
+
+	switch rule.name {
+	case "uuid":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected uuid string")
+		}
+		if _, err := uuid.Parse(s); err != nil {
+			return err
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(rule.param, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rule %s=%s", rule.name, rule.param)
+		}
+		size, ok := getArgSize(value)
+		if !ok {
+			return nil
+		}
+		if rule.name == "min" && size < limit {
+			return fmt.Errorf("must be at least %s", rule.param)
+		}
+		if rule.name == "max" && size > limit {
+			return fmt.Errorf("must be at most %s", rule.param)
+		}
+	case "oneof":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected string")
+		}
+		for _, option := range strings.Split(rule.param, "|") {
+			if s == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", rule.param)
+	case "maxBytes":
+		limit, err := strconv.Atoi(rule.param)
+		if err != nil {
+			return fmt.Errorf("invalid rule %s=%s", rule.name, rule.param)
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		// Escaped characters take up to 6 bytes, e.g. "<" is encoded as "\u003c".
+		encoded, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		if len(encoded) > limit {
+			return fmt.Errorf("must be at most %s bytes", rule.param)
+		}
+	}
+
+	return nil
+}
+
+// Gets string length in runes, array length or number value.
+func getArgSize(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case string:
+		return float64(utf8.RuneCountInString(v)), true
+	case []interface{}:
+		return float64(len(v)), true
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Gets the arg name from the json tag. Skips ignored and unexported fields.
+func getArgName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+
+	return name, true
+}
+
+func getArgRules(field reflect.StructField) []argRule {
+	tag := field.Tag.Get(validateTag)
+	if tag == "" {
+		return nil
+	}
+
+	var rules []argRule
+	for _, r := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(r, "=")
+		rules = append(rules, argRule{name: name, param: param})
+	}
+
+	return rules
+}
+
+func argsPath(path string, name string) string {
+	if path == "" {
+		if name == "" {
+			return "args"
+		}
+		return name
+	}
+	if name == "" {
+		return path
+	}
+	return path + "." + name
+}
@@ -0,0 +1,187 @@
+package web
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Metadata of the channel known to this instance.
+type websocketChannelInfo struct {
+	Id       uuid.UUID
+	Category string
+	// Space name or server address, empty for the other channels.
+	Name string
+	// Users of the private channel, the host first.
+	Members []uuid.UUID
+}
+
+// Channel with its subscribers connected to this instance.
+type websocketChannel struct {
+	websocketChannelInfo
+	// Subscribed clients by the client id.
+	clients map[uuid.UUID]*WebsocketClient
+	// Subscribed sessions waiting for their clients to reconnect.
+	sessions map[*websocketSession]struct{}
+}
+
+// Channels by the channel id. Allows to find the channel subscribers without scanning all the clients.
+type websocketChannelRegistry struct {
+	lock     sync.RWMutex
+	channels map[uuid.UUID]*websocketChannel
+}
+
+func newWebsocketChannelRegistry() *websocketChannelRegistry {
+	return &websocketChannelRegistry{
+		channels: make(map[uuid.UUID]*websocketChannel),
+	}
+}
+
+// Returns the channel, creating it with the unknown category if it is not registered yet. Must be called under the lock.
+func (registry *websocketChannelRegistry) getOrCreate(channelId uuid.UUID) *websocketChannel {
+	channel, ok := registry.channels[channelId]
+	if !ok {
+		channel = &websocketChannel{
+			websocketChannelInfo: websocketChannelInfo{Id: channelId, Category: CategoryUnknown},
+			clients:              make(map[uuid.UUID]*WebsocketClient),
+			sessions:             make(map[*websocketSession]struct{}),
+		}
+		registry.channels[channelId] = channel
+	}
+	return channel
+}
+
+// Removes the channel left without the subscribers, so the registry does not grow with every channel ever subscribed.
+// The system and the general channels are kept. Must be called under the lock.
+func (registry *websocketChannelRegistry) removeIfEmpty(channel *websocketChannel) {
+	if len(channel.clients) > 0 || len(channel.sessions) > 0 {
+		return
+	}
+	if channel.Category == CategorySystem || channel.Category == CategoryGeneral {
+		return
+	}
+	delete(registry.channels, channel.Id)
+}
+
+// Registers the channel. Metadata of the channel registered before is kept.
+func (registry *websocketChannelRegistry) register(info websocketChannelInfo) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	channel := registry.getOrCreate(info.Id)
+	if channel.Category == CategoryUnknown {
+		channel.websocketChannelInfo = info
+	}
+}
+
+func (registry *websocketChannelRegistry) get(channelId uuid.UUID) (websocketChannelInfo, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	channel, ok := registry.channels[channelId]
+	if !ok {
+		return websocketChannelInfo{Id: channelId, Category: CategoryUnknown}, false
+	}
+	return channel.websocketChannelInfo, true
+}
+
+func (registry *websocketChannelRegistry) getCategory(channelId uuid.UUID) string {
+	info, _ := registry.get(channelId)
+	return info.Category
+}
+
+// Subscribes the client for the channel. Ignored for the disconnected clients, their queues are closed before
+// they are unsubscribed from all the channels.
+func (registry *websocketChannelRegistry) subscribe(channelId uuid.UUID, client *WebsocketClient) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if client.send.isClosed() {
+		return
+	}
+
+	registry.getOrCreate(channelId).clients[client.Id] = client
+}
+
+func (registry *websocketChannelRegistry) unsubscribe(channelId uuid.UUID, client *WebsocketClient) {
+	registry.lock.Lock()
+	if channel, ok := registry.channels[channelId]; ok {
+		delete(channel.clients, client.Id)
+		registry.removeIfEmpty(channel)
+	}
+	registry.lock.Unlock()
+}
+
+// Returns the snapshot of the channel subscribers: the connected clients and the detached sessions.
+func (registry *websocketChannelRegistry) getSubscribers(channelId uuid.UUID) ([]*WebsocketClient, []*websocketSession) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	channel, ok := registry.channels[channelId]
+	if !ok {
+		return nil, nil
+	}
+
+	clients := make([]*WebsocketClient, 0, len(channel.clients))
+	for _, client := range channel.clients {
+		clients = append(clients, client)
+	}
+
+	sessions := make([]*websocketSession, 0, len(channel.sessions))
+	for session := range channel.sessions {
+		sessions = append(sessions, session)
+	}
+
+	return clients, sessions
+}
+
+// Unsubscribes the client from the channels.
+func (registry *websocketChannelRegistry) removeClient(client *WebsocketClient, channelIds []uuid.UUID) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for _, channelId := range channelIds {
+		if channel, ok := registry.channels[channelId]; ok {
+			delete(channel.clients, client.Id)
+			registry.removeIfEmpty(channel)
+		}
+	}
+}
+
+// Unsubscribes the expired session from the channels.
+func (registry *websocketChannelRegistry) removeSession(session *websocketSession, channelIds []uuid.UUID) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for _, channelId := range channelIds {
+		if channel, ok := registry.channels[channelId]; ok {
+			delete(channel.sessions, session)
+			registry.removeIfEmpty(channel)
+		}
+	}
+}
+
+// Replaces the subscriptions of the detached client with the subscriptions of its session at once, so each message
+// reaches either the client or the session.
+func (registry *websocketChannelRegistry) detachSession(client *WebsocketClient, session *websocketSession, channelIds []uuid.UUID) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for _, channelId := range channelIds {
+		channel := registry.getOrCreate(channelId)
+		delete(channel.clients, client.Id)
+		channel.sessions[session] = struct{}{}
+	}
+}
+
+// Replaces the subscriptions of the session with the subscriptions of the client resuming it.
+func (registry *websocketChannelRegistry) attachSession(client *WebsocketClient, session *websocketSession, channelIds []uuid.UUID) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for _, channelId := range channelIds {
+		channel := registry.getOrCreate(channelId)
+		delete(channel.sessions, session)
+		channel.clients[client.Id] = client
+	}
+}
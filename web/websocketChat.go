@@ -0,0 +1,109 @@
+package web
+
+import (
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	config "github.com/spf13/viper"
+)
+
+// Chat settings, read from the "rpc.chat" configuration section.
+type ChatConfig struct {
+	// Maximal number of the latest channel messages returned with the subscribe responses. Disabled if zero.
+	BackfillLimit int
+	// Maximal number of the undelivered private channel messages pushed on connect per channel. Disabled if zero.
+	BacklogLimit int
+	// Maximal number of the group channel members. Unlimited if zero.
+	MaxGroupMembers int
+}
+
+// Reads the "rpc.chat" configuration section.
+func newChatConfigFromConfig() ChatConfig {
+	return ChatConfig{
+		BackfillLimit:   config.GetInt("rpc.chat.backfillLimit"),
+		BacklogLimit:    config.GetInt("rpc.chat.backlogLimit"),
+		MaxGroupMembers: config.GetInt("rpc.chat.maxGroupMembers"),
+	}
+}
+
+// Reads the latest channel messages requested with the subscription, capped by the backfill limit. Returns nil if no
+// messages are requested or they can not be read, the subscription does not depend on them.
+func (server *WebsocketServer) getChannelBackfill(channelId uuid.UUID, count int) *WebsocketHistory {
+	if count > server.chat.BackfillLimit {
+		count = server.chat.BackfillLimit
+	}
+
+	if count <= 0 {
+		return nil
+	}
+
+	history, err := server.getChannelHistory(models.ChatMessageQuery{ChannelId: channelId.String(), Limit: count})
+	if err != nil {
+		log.Errorf("got an error reading backfill of channel {%s}: %s", channelId, err.Error())
+		return nil
+	}
+
+	return history
+}
+
+// Pushes the private channel messages not delivered to the user by any server instance, the oldest first, and records
+// them delivered. The rest of the messages beyond the backlog limit can be read with the channel history requests.
+func (client *WebsocketClient) sendPrivateChannelBacklog() {
+	server := client.server
+	if server.chat.BacklogLimit <= 0 {
+		return
+	}
+
+	channels, err := server.store.GetPrivateChannelsByUserId(client.user.Id)
+	if err != nil {
+		log.Errorf("got an error getting private channels of user {%s}: %s", client.user.Id, err.Error())
+		return
+	}
+
+	for _, channel := range channels {
+		messages, err := server.store.ClaimPrivateChannelBacklog(channel.Id, client.user.Id, server.chat.BacklogLimit)
+		if err != nil {
+			log.Errorf("got an error reading backlog of channel {%s}: %s", channel.Id, err.Error())
+			return
+		}
+
+		if len(messages) == 0 {
+			continue
+		}
+
+		payload := WebsocketPayload{
+			Status:    handlerStatusOk,
+			Message:   MessageNotifyPrivateChannelBacklog,
+			ChannelId: channel.Id.String(),
+			Category:  CategoryPrivate,
+			History:   server.newWebsocketHistory(messages, len(messages) == server.chat.BacklogLimit),
+		}
+		if err = client.SendPushMessage(ChatTopic, payload); err != nil {
+			log.Errorf("got an error sending backlog of channel {%s} to websocket client {%s}: %s", channel.Id, client.Id, err.Error())
+			return
+		}
+	}
+}
+
+// Records the new private channel message delivered to the users of the clients connected to this instance, so it is
+// not pushed with the backlog of the users by any instance. The notifications about the messages are not recorded.
+func (server *WebsocketServer) recordPrivateMessageDelivered(channelId uuid.UUID, payload WebsocketPayload, clients []*WebsocketClient) {
+	if payload.MessageId == "" || payload.Update != nil || len(clients) == 0 || server.channels.getCategory(channelId) != CategoryPrivate {
+		return
+	}
+
+	userIds := make([]uuid.UUID, 0, 2)
+	for _, client := range clients {
+		if user := client.getUser(); user != nil && !containsUUID(userIds, user.Id) {
+			userIds = append(userIds, user.Id)
+		}
+	}
+
+	go func() {
+		for _, userId := range userIds {
+			if err := server.store.AddPrivateMessageDelivery(payload.MessageId, userId); err != nil {
+				log.Errorf("got an error recording message {%s} delivered to user {%s}: %s", payload.MessageId, userId, err.Error())
+			}
+		}
+	}()
+}
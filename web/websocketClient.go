@@ -1,19 +1,17 @@
 package web
 
 import (
-	"bytes"
 	"dev.hackerman.me/artheon/artheon-rpc/models"
-	"fmt"
+	"encoding/binary"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
-)
+var newline = []byte{'\n'}
 
 // An instance created for each websocket connection.
 type WebsocketClient struct {
@@ -23,46 +21,73 @@ type WebsocketClient struct {
 	server *WebsocketServer
 	// The websocket connection.
 	conn *websocket.Conn
-	// Buffered channel of outbounds messages.
-	send chan []byte
-	// Requests
-	requests map[uuid.UUID]time.Time
+	// Address of the client counted by the connection limits.
+	remoteIp string
+	// Message serializer negotiated via the websocket subprotocol.
+	serializer WebsocketMessageSerializer
+	// Queue of outbound messages.
+	send *outboundQueue
+	// Requests sent to the client awaiting for the response
+	requests map[uuid.UUID]*websocketPendingRequest
+	// Guards requests
+	requestsLock sync.Mutex
 	// Channels the client is subscribed for
 	channels []uuid.UUID
-	// Rpc request handlers
-	handlers map[string]websocketRequestHandler
 	// Owning user
 	user *models.User
+	// Identity verified by the authenticator
+	identity *AuthIdentity
+	// Request rate limit state
+	rateLimiter *clientRateLimiter
+	// Smoothed round trip time measured with the websocket pings, nanoseconds
+	rtt atomic.Int64
+	// Resumable session, set after the connect handshake
+	session *websocketSession
+	// Presence reported by this device, the presence of the user is computed across the devices
+	presence models.Presence
+	// Time of the last presence update
+	presenceUpdatedAt time.Time
+	// Guards channels, user, identity, session and presence. The user, identity, session and presence are written by the
+	// read goroutine only, so it may read them without locking, while other goroutines must use the getters. The channels
+	// are also changed by other goroutines (backplane events, group and private channel joins), so even the read
+	// goroutine must access them through the methods.
+	lock sync.RWMutex
 	// Is disconnecting
 	disconnecting chan bool
 }
 
 type websocketRequestHandler func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) error
 
-// Register an RPC handler function.
-func (client *WebsocketClient) registerHandler(topic WebsocketTopic, method string, handler websocketRequestHandler) {
-	name := fmt.Sprintf("%d.%s", topic, method)
-	client.handlers[name] = handler
-}
-
 // Subscribe the client for the channel.
 func (client *WebsocketClient) addChannelSubscription(channelId uuid.UUID) {
-	for _, c := range client.channels {
-		if c == channelId {
-			log.Printf("client {%s} had already subscribed for the channel {%s}", client.Id.String(), channelId.String())
-			return
-		}
+	client.lock.Lock()
+	subscribed := containsUUID(client.channels, channelId)
+	if !subscribed {
+		client.channels = append(client.channels, channelId)
 	}
+	client.lock.Unlock()
+
+	client.server.channels.subscribe(channelId, client)
 
-	client.channels = append(client.channels, channelId)
-	log.Printf("client {%s} subscribed for the channel {%s} of category {%s}", client.Id.String(), channelId.String(), getCategoryByChannelId(&channelId))
+	if subscribed {
+		log.Printf("client {%s} had already subscribed for the channel {%s}", client.Id.String(), channelId.String())
+		return
+	}
+
+	log.Printf("client {%s} subscribed for the channel {%s} of category {%s}", client.Id.String(), channelId.String(), client.server.getCategoryByChannelId(&channelId))
 }
 
 // Unsubscribe the client from the channel.
 func (client *WebsocketClient) removeChannelSubscription(channelId uuid.UUID) {
+	client.server.channels.unsubscribe(channelId, client)
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
 	for idx, v := range client.channels {
 		if v == channelId {
-			client.channels = append(client.channels[0:idx], client.channels[idx+1:]...)
+			// Copy on write, the slices returned by getChannels are shared.
+			client.channels = append(append([]uuid.UUID{}, client.channels[0:idx]...), client.channels[idx+1:]...)
 			log.Printf("client {%s} unsubscribed from the channel {%s}", client.Id.String(), channelId.String())
 			return
 		}
@@ -70,6 +95,119 @@ func (client *WebsocketClient) removeChannelSubscription(channelId uuid.UUID) {
 	log.Printf("client {%s} was not subscribed for the channel {%s}", client.Id.String(), channelId.String())
 }
 
+func (client *WebsocketClient) isSubscribed(channelId uuid.UUID) bool {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return containsUUID(client.channels, channelId)
+}
+
+// Returns the channels the client is subscribed for. The slice must not be modified.
+func (client *WebsocketClient) getChannels() []uuid.UUID {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.channels
+}
+
+func (client *WebsocketClient) setChannels(channels []uuid.UUID) {
+	client.lock.Lock()
+	client.channels = channels
+	client.lock.Unlock()
+}
+
+func (client *WebsocketClient) getUser() *models.User {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.user
+}
+
+func (client *WebsocketClient) setUser(user *models.User) {
+	client.lock.Lock()
+	client.user = user
+	client.lock.Unlock()
+}
+
+func (client *WebsocketClient) isAuthenticated() bool {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.identity != nil
+}
+
+func (client *WebsocketClient) getIdentity() *AuthIdentity {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.identity
+}
+
+func (client *WebsocketClient) setIdentity(identity *AuthIdentity) {
+	client.lock.Lock()
+	client.identity = identity
+	client.lock.Unlock()
+}
+
+func (client *WebsocketClient) getSession() *websocketSession {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.session
+}
+
+func (client *WebsocketClient) setSession(session *websocketSession) {
+	client.lock.Lock()
+	client.session = session
+	client.lock.Unlock()
+}
+
+// Queues the serialized message for the write goroutine without blocking. When the queue is full, the oldest chat or
+// analytics message is dropped or the client is disconnected, according to the slow consumer policy.
+func (client *WebsocketClient) enqueue(message []byte, priority outboundPriority) error {
+	dropOldest := client.server.outbound.Policy != SlowConsumerDisconnect
+	if client.send.push(message, priority, dropOldest) {
+		return nil
+	}
+
+	// Close the queue so the following messages are rejected at once and the client is disconnected only once.
+	if client.send.close() {
+		stats := client.send.getStats()
+		log.Warnf("client {%s} is too slow, dropped: {%d}, high water mark: {%d}", client.Id, stats.Dropped, stats.HighWaterMark)
+		// Do not block the sender on the stalled connection.
+		go client.disconnect(websocket.CloseTryAgainLater, "too slow to read messages")
+	}
+
+	return errClientTooSlow
+}
+
+// Returns the counters of the outbound queue of the client.
+func (client *WebsocketClient) GetOutboundStats() OutboundQueueStats {
+	return client.send.getStats()
+}
+
+func (client *WebsocketClient) getPresence() (models.Presence, time.Time) {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.presence, client.presenceUpdatedAt
+}
+
+func (client *WebsocketClient) setPresence(presence models.Presence, updatedAt time.Time) {
+	client.lock.Lock()
+	client.presence = presence
+	client.presenceUpdatedAt = updatedAt
+	client.lock.Unlock()
+}
+
+// Sends the close frame with the code and reason and closes the connection. Safe to call from any goroutine,
+// the read goroutine then fails and unregisters the client.
+func (client *WebsocketClient) disconnect(code int, reason string) {
+	client.sendClose(code, reason)
+	_ = client.conn.Close()
+}
+
+// Sends the close frame with the code and reason leaving the connection open until the client completes the close
+// handshake. Safe to call from any goroutine.
+func (client *WebsocketClient) sendClose(code int, reason string) {
+	log.Printf("closing client {%s}, code: {%d}, reason: {%s}", client.Id, code, reason)
+
+	_ = client.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+}
+
 // Close the websocket connection.
 func (client *WebsocketClient) closeWebsocket(reason string) {
 	_ = client.conn.WriteMessage(websocket.CloseMessage, []byte(reason))
@@ -77,11 +215,11 @@ func (client *WebsocketClient) closeWebsocket(reason string) {
 	client.disconnecting <- true
 
 	// Unsubscribe the client from all channels
-	for _, v := range client.channels {
+	for _, v := range client.getChannels() {
 		client.removeChannelSubscription(v)
 	}
 
-	close(client.send)
+	client.send.close()
 }
 
 // Connection established callback. Called after client connection. Sends special message to the frontend.
@@ -100,14 +238,20 @@ func (client *WebsocketClient) onConnectionEstablished() {
 // reader per connection by executing all reads from this goroutine.
 func (client *WebsocketClient) goSocketRead() {
 	defer func() {
-		client.server.unregister <- client
+		select {
+		case client.server.unregister <- client:
+		case <-client.server.done:
+		}
+		client.disconnecting <- true
 	}()
 
 	client.conn.SetReadLimit(maxMessageSize)
 
 	_ = client.conn.SetReadDeadline(time.Now().Add(pongWait))
 	client.conn.SetPongHandler(func(message string) error {
-		_ = client.conn.SetReadDeadline(time.Now().Add(pongWait))
+		now := time.Now()
+		_ = client.conn.SetReadDeadline(now.Add(pongWait))
+		client.onPong([]byte(message), now)
 		return nil
 	})
 
@@ -119,18 +263,17 @@ func (client *WebsocketClient) goSocketRead() {
 			break
 		}
 
-		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-
-		websocketMessage, err := client.server.serializer.deserialize(message)
-
-		if err != nil {
-			log.Errorf("got an invalid websocket message: %s", err.Error())
-		}
-
-		err = client.onMessageReceived(websocketMessage)
+		// Messages of the batch are handled in order, each request gets its own response.
+		for index, decoded := range client.serializer.Deserialize(message) {
+			if decoded.Err != nil {
+				err = client.onMessageDecodeFailed(decoded.Message, index, decoded.Err)
+			} else {
+				err = client.onMessageReceived(decoded.Message)
+			}
 
-		if err != nil {
-			log.Errorf("got an error reading data from socket: %s", err)
+			if err != nil {
+				log.Errorf("got an error reading data from socket: %s", err)
+			}
 		}
 	}
 }
@@ -148,38 +291,29 @@ func (client *WebsocketClient) goSocketWrite() {
 
 	for {
 		select {
-		case message, ok := <-client.send:
-			_ = client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-
-			if !ok {
-				log.Printf("unable to get the message from the client send channel: %s", message)
-				// The server closed the channel.
-				_ = client.conn.Close()
-				return
-			}
-
-			w, err := client.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				log.Errorf("got an error trying to get websocket connection writer: %s", err.Error())
-				return
-			}
-			_, _ = w.Write(message)
+		case <-client.send.notify:
+			// Write the queued messages in batches by the priority, so the higher priority messages queued meanwhile
+			// are written before the rest of the lower priority ones.
+			for {
+				messages, ok := client.send.pop(outboundWriteBatchSize)
+				if !ok {
+					// The queue has been closed.
+					return
+				}
 
-			// Add queued messages to the current websocket message.
-			n := len(client.send)
-			for i := 0; i < n; i++ {
-				_, _ = w.Write(newline)
-				_, _ = w.Write(<-client.send)
-			}
+				if len(messages) == 0 {
+					break
+				}
 
-			if err := w.Close(); err != nil {
-				log.Errorf("got an error trying to close websocket connection writer: %s", err.Error())
-				return
+				if err := client.writeMessages(messages); err != nil {
+					return
+				}
 			}
 		case <-ticker.C:
 			_ = client.conn.SetWriteDeadline(time.Now().Add(writeWait))
 
-			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			// The ping carries the send time to measure the round trip time with the pong.
+			if err := client.conn.WriteMessage(websocket.PingMessage, newPingPayload(time.Now())); err != nil {
 				log.Errorf("got an error trying to write a ping message to a websocket: %s", err.Error())
 				return
 			}
@@ -187,25 +321,95 @@ func (client *WebsocketClient) goSocketWrite() {
 	}
 }
 
+// Writes the messages to the websocket connection.
+func (client *WebsocketClient) writeMessages(messages [][]byte) error {
+	_ = client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	frameType := client.serializer.FrameType()
+
+	// Binary messages can not be delimited, so each one is sent in its own frame.
+	if frameType == websocket.BinaryMessage {
+		for _, message := range messages {
+			if err := client.conn.WriteMessage(frameType, message); err != nil {
+				log.Errorf("got an error trying to write a binary message to a websocket: %s", err.Error())
+				return err
+			}
+		}
+		return nil
+	}
+
+	w, err := client.conn.NextWriter(frameType)
+	if err != nil {
+		log.Errorf("got an error trying to get websocket connection writer: %s", err.Error())
+		return err
+	}
+
+	// Send the messages in the single websocket message.
+	for i, message := range messages {
+		if i > 0 {
+			_, _ = w.Write(newline)
+		}
+		_, _ = w.Write(message)
+	}
+
+	if err := w.Close(); err != nil {
+		log.Errorf("got an error trying to close websocket connection writer: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func newPingPayload(now time.Time) []byte {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(now.UnixNano()))
+	return payload
+}
+
+// Updates the smoothed round trip time with the pong echoing the ping payload, as TCP does with the gain of 1/8.
+func (client *WebsocketClient) onPong(payload []byte, now time.Time) {
+	if len(payload) != 8 {
+		return
+	}
+
+	sample := now.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(payload))))
+	if sample < 0 {
+		return
+	}
+
+	rtt := time.Duration(client.rtt.Load())
+	if rtt == 0 {
+		rtt = sample
+	} else {
+		rtt += (sample - rtt) / 8
+	}
+	client.rtt.Store(int64(rtt))
+}
+
+// Returns the smoothed round trip time, zero until the first pong.
+func (client *WebsocketClient) getRtt() time.Duration {
+	return time.Duration(client.rtt.Load())
+}
+
 func (client *WebsocketClient) goRequestDeadline() {
 	ticker := time.NewTicker(pingPeriod)
+	retransmitTicker := time.NewTicker(retransmitPeriod)
 
 	defer func() {
 		ticker.Stop()
+		retransmitTicker.Stop()
 		_ = client.conn.Close()
 	}()
 
 	for {
 		select {
 		case <-ticker.C:
-			for message, expiresAt := range client.requests {
-				if time.Now().Sub(expiresAt) > 0 {
-					log.Errorf("got an timeout processing request: %+v", message)
-					delete(client.requests, message)
-				}
-			}
+			client.expirePendingRequests(time.Now())
+		case now := <-retransmitTicker.C:
+			client.retransmitUnacknowledged(now)
 		case disconnecting := <-client.disconnecting:
 			if disconnecting {
+				client.cancelPendingRequests()
 				return
 			}
 		}
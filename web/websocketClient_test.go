@@ -0,0 +1,187 @@
+package web
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Changes the channel subscriptions of hundreds of clients from their read goroutines while the other goroutines join
+// the private channels, remove the group members, broadcast to the channels and drain the queues. Run with -race.
+func TestWebsocketClientChannelsConcurrentAccess(t *testing.T) {
+	const (
+		userCount      = 100
+		devicesPerUser = 3
+		iterations     = 50
+	)
+
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(level)
+
+	server := newTestWebsocketServer()
+
+	spaceChannels := make([]uuid.UUID, 8)
+	for i := range spaceChannels {
+		spaceChannels[i] = uuid.New()
+		server.addSpaceChannel(spaceChannels[i], fmt.Sprintf("space %d", i))
+	}
+	groupChannel := uuid.New()
+	server.addGroupChannel(groupChannel, "group")
+
+	users := make([]*models.User, userCount)
+	clients := make([]*WebsocketClient, 0, userCount*devicesPerUser)
+	for i := range users {
+		users[i] = &models.User{Id: uuid.New()}
+		for j := 0; j < devicesPerUser; j++ {
+			clients = append(clients, newTestWebsocketClient(t, server, users[i]))
+		}
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// Read goroutines of the clients.
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *WebsocketClient) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				channelId := spaceChannels[(i+n)%len(spaceChannels)]
+				client.addChannelSubscription(channelId)
+				client.addChannelSubscription(groupChannel)
+				_ = client.isSubscribed(channelId)
+				for k := 0; k < len(spaceChannels); k++ {
+					_ = len(client.getChannels())
+					// Let the other goroutines change the channels between the reads.
+					runtime.Gosched()
+				}
+				client.removeChannelSubscription(channelId)
+			}
+		}(i, client)
+	}
+
+	// Private channels opened by the other users or announced by the backplane.
+	privateChannels := make([]uuid.UUID, userCount)
+	for i := range users {
+		host, guest := users[i], users[(i+1)%userCount]
+		privateChannels[i] = getPrivateChannelId(host.Id, guest.Id)
+
+		wg.Add(1)
+		go func(channelId uuid.UUID, hostId uuid.UUID, guestId uuid.UUID) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				server.joinPrivateChannel(channelId, hostId, guestId)
+			}
+		}(privateChannels[i], host.Id, guest.Id)
+	}
+
+	// Group members removed by the other clients or by the backplane events.
+	for i := 0; i < userCount; i += 2 {
+		wg.Add(1)
+		go func(userId uuid.UUID) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				server.onBackplaneEvent(&BackplaneEvent{Kind: BackplaneGroupRemoved, ChannelId: groupChannel, UserIds: []uuid.UUID{userId}})
+			}
+		}(users[i].Id)
+	}
+
+	// Messages broadcast to the channels.
+	for _, channelId := range append(spaceChannels, groupChannel) {
+		wg.Add(1)
+		go func(channelId uuid.UUID) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				server.broadcastMessageToLocalChannel(channelId, WebsocketPayload{ChannelId: channelId.String(), Message: "message"})
+			}
+		}(channelId)
+	}
+
+	// Write goroutines of the clients.
+	var writers sync.WaitGroup
+	for _, client := range clients {
+		writers.Add(1)
+		go func(client *WebsocketClient) {
+			defer writers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-client.send.notify:
+					client.send.pop(outboundWriteBatchSize)
+				}
+			}
+		}(client)
+	}
+
+	wg.Wait()
+	close(stop)
+	writers.Wait()
+
+	for _, client := range clients {
+		channels := client.getChannels()
+
+		seen := make(map[uuid.UUID]bool, len(channels))
+		for _, channelId := range channels {
+			if seen[channelId] {
+				t.Errorf("client {%s} is subscribed for the channel {%s} twice", client.Id, channelId)
+			}
+			seen[channelId] = true
+		}
+
+		userIndex := -1
+		for i, user := range users {
+			if user == client.getUser() {
+				userIndex = i
+			}
+		}
+
+		// The private channels of the user are never left.
+		for _, channelId := range []uuid.UUID{privateChannels[userIndex], privateChannels[(userIndex+userCount-1)%userCount]} {
+			if !client.isSubscribed(channelId) {
+				t.Errorf("client {%s} is not subscribed for the private channel {%s}", client.Id, channelId)
+			}
+
+			subscribers, _ := server.channels.getSubscribers(channelId)
+			if !containsClient(subscribers, client) {
+				t.Errorf("client {%s} is not a subscriber of the private channel {%s}", client.Id, channelId)
+			}
+		}
+	}
+}
+
+// Unsubscribes the last client from the channel. The channel is removed from the registry, while the system and the
+// general channels are kept.
+func TestWebsocketClientUnsubscribeRemovesEmptyChannel(t *testing.T) {
+	defer quietTestLog()()
+
+	server := newTestWebsocketServer()
+
+	channelId := uuid.New()
+	server.addSpaceChannel(channelId, "space")
+
+	client := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+	client.addChannelSubscription(channelId)
+	client.addChannelSubscription(server.SystemChannel)
+	client.addChannelSubscription(server.GeneralChannel)
+
+	client.removeChannelSubscription(channelId)
+	client.removeChannelSubscription(server.SystemChannel)
+	client.removeChannelSubscription(server.GeneralChannel)
+
+	if _, ok := server.channels.get(channelId); ok {
+		t.Errorf("channel {%s} is kept without the subscribers", channelId)
+	}
+
+	for _, channelId := range []uuid.UUID{server.SystemChannel, server.GeneralChannel} {
+		if info, ok := server.channels.get(channelId); !ok || info.Category == CategoryUnknown {
+			t.Errorf("channel {%s} has been removed", channelId)
+		}
+	}
+}
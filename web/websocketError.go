@@ -0,0 +1,78 @@
+package web
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Machine-readable error codes returned to the clients. Codes are a part of the protocol and must never change.
+type WebsocketErrorCode string
+
+const (
+	ErrorCodeNotAuthenticated    WebsocketErrorCode = "not_authenticated"    // The client has not completed the connect handshake or the session token is invalid.
+	ErrorCodeForbidden           WebsocketErrorCode = "forbidden"            // The user is not allowed to perform the request.
+	ErrorCodeNotSubscribed       WebsocketErrorCode = "not_subscribed"       // The client is not subscribed to the channel.
+	ErrorCodeNotFound            WebsocketErrorCode = "not_found"            // The requested entity does not exist.
+	ErrorCodeInvalidArgs         WebsocketErrorCode = "invalid_args"         // The request args are missing or malformed.
+	ErrorCodeInvalidMessage      WebsocketErrorCode = "invalid_message"      // The message of the batch can not be decoded.
+	ErrorCodeUnknownMethod       WebsocketErrorCode = "unknown_method"       // The server has no handler for the topic and method.
+	ErrorCodeRateLimited         WebsocketErrorCode = "rate_limited"         // The client has exceeded the request rate limit.
+	ErrorCodeUpstreamUnavailable WebsocketErrorCode = "upstream_unavailable" // The database or an external service has failed.
+	ErrorCodeInternal            WebsocketErrorCode = "internal"             // Unexpected server error.
+)
+
+// User-safe messages for the error codes. Clients are expected to localize errors by the code.
+var websocketErrorMessages = map[WebsocketErrorCode]string{
+	ErrorCodeNotAuthenticated:    "client is not authenticated",
+	ErrorCodeForbidden:           "request is not allowed",
+	ErrorCodeNotSubscribed:       "client is not subscribed to the channel",
+	ErrorCodeNotFound:            "requested entity does not exist",
+	ErrorCodeInvalidArgs:         "invalid request args",
+	ErrorCodeInvalidMessage:      "invalid message",
+	ErrorCodeUnknownMethod:       "unknown method",
+	ErrorCodeRateLimited:         "too many requests",
+	ErrorCodeUpstreamUnavailable: "service is temporarily unavailable",
+	ErrorCodeInternal:            "internal server error",
+}
+
+// Error returned to the client in the response payload.
+type WebsocketError struct {
+	Code    WebsocketErrorCode     `json:"code"`              // Stable machine-readable code.
+	Message string                 `json:"message"`           // User-safe description, never includes internal details.
+	Details map[string]interface{} `json:"details,omitempty"` // Optional details, e.g. the name of the invalid argument.
+}
+
+func newWebsocketError(code WebsocketErrorCode, details map[string]interface{}) *WebsocketError {
+	message, ok := websocketErrorMessages[code]
+	if !ok {
+		code = ErrorCodeInternal
+		message = websocketErrorMessages[ErrorCodeInternal]
+	}
+
+	return &WebsocketError{
+		Code:    code,
+		Message: message,
+		Details: details,
+	}
+}
+
+// Logs the internal cause and responds to the request with the user-safe error.
+func (client *WebsocketClient) sendErrorResponse(websocketMessage *WebsocketMessage, code WebsocketErrorCode, cause error) error {
+	return client.sendErrorResponseWithDetails(websocketMessage, code, cause, nil)
+}
+
+// Logs the internal cause and responds to the request with the user-safe error and details.
+func (client *WebsocketClient) sendErrorResponseWithDetails(websocketMessage *WebsocketMessage, code WebsocketErrorCode, cause error, details map[string]interface{}) error {
+	websocketError := newWebsocketError(code, details)
+
+	if cause != nil {
+		log.Errorf("rpc error, client: {%s}, topic: {%d}, method: {%s}, code: {%s}: %s", client.Id, websocketMessage.Topic, websocketMessage.Method, websocketError.Code, cause.Error())
+	}
+
+	result := WebsocketPayload{
+		Status:  handlerStatusError,
+		Message: websocketError.Message,
+		Error:   websocketError,
+	}
+
+	return client.sendResponseMessage(websocketMessage, result)
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"database/sql"
+	"errors"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errGroupOwnerRequired  = errors.New("only the owner may change the members of the group")
+	errGroupMemberRequired = errors.New("user is not a member of the group")
+)
+
+func newWebsocketGroup(channel *models.GroupChannel, member *models.User) *WebsocketGroup {
+	return &WebsocketGroup{
+		Id:        channel.Id.String(),
+		Name:      channel.Name,
+		OwnerId:   channel.OwnerId,
+		MemberIds: channel.MemberIds,
+		Member:    member,
+	}
+}
+
+// Registers the group channel if it is not registered yet.
+func (server *WebsocketServer) addGroupChannel(channelId uuid.UUID, name string) {
+	server.channels.register(websocketChannelInfo{Id: channelId, Category: CategoryGroup, Name: name})
+}
+
+// Reads the group channel with its members from the database, as the members may be changed by any instance. Returns
+// nil if the channel is not a group one.
+func (server *WebsocketServer) getGroupChannel(channelId uuid.UUID) (*models.GroupChannel, error) {
+	channel, err := server.store.GetGroupChannelById(channelId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	server.addGroupChannel(channel.Id, channel.Name)
+
+	return channel, nil
+}
+
+// Unsubscribes the devices and the detached sessions of the user connected to this instance from the group channel.
+func (server *WebsocketServer) removeLocalGroupMember(channelId uuid.UUID, userId uuid.UUID) {
+	// Sessions go first, so the session resumed meanwhile leaves its client subscribed, which is unsubscribed next.
+	_, sessions := server.channels.getSubscribers(channelId)
+	for _, session := range sessions {
+		if session.userId == userId {
+			session.removeChannel(channelId)
+			server.channels.removeSession(session, []uuid.UUID{channelId})
+		}
+	}
+
+	for _, client := range server.users.getClients(userId) {
+		client.removeChannelSubscription(channelId)
+	}
+}
+
+// Unsubscribes the removed member on all the instances and notifies the group channel.
+func (server *WebsocketServer) removeGroupMember(channelId uuid.UUID, channel *models.GroupChannel, member *models.User, actor *models.User) {
+	server.removeLocalGroupMember(channelId, member.Id)
+	server.publish(&BackplaneEvent{
+		Kind:      BackplaneGroupRemoved,
+		ChannelId: channelId,
+		UserIds:   []uuid.UUID{member.Id},
+	})
+
+	// The channel left by the last member has been deleted.
+	if channel == nil {
+		return
+	}
+
+	server.broadcastMessageToChannel(channelId, WebsocketPayload{
+		Status:    handlerStatusOk,
+		Message:   MessageNotifyGroupMemberRemoved,
+		Sender:    actor,
+		ChannelId: channelId.String(),
+		Category:  CategoryGroup,
+		Group:     newWebsocketGroup(channel, member),
+	})
+}
+
+// Responds with the error of the group channel request.
+func (client *WebsocketClient) sendGroupError(websocketMessage *WebsocketMessage, channelId uuid.UUID, err error) error {
+	details := map[string]interface{}{"channelId": channelId.String()}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeNotFound, errGroupMemberRequired, details)
+	case errors.Is(err, errGroupOwnerRequired), errors.Is(err, errGroupMemberRequired), errors.Is(err, models.ErrGroupChannelFull):
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeForbidden, err, details)
+	default:
+		log.Errorf("got an error changing group channel {%s}: %s", channelId, err.Error())
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeUpstreamUnavailable, err, details)
+	}
+}
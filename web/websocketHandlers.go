@@ -1,96 +1,136 @@
 package web
 
 import (
+	"database/sql"
 	"dev.hackerman.me/artheon/artheon-rpc/models"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 const (
-	handlerArgsChannel      = "channelId"
-	handlerArgsUserId       = "key"
-	handlerArgsMessage      = "message"
-	handlerArgsPresence     = "presence"
-	handlerArgsVivoxPayload = "vivoxPayload"
-	handlerStatusOk         = "ok"
-	handlerStatusError      = "error"
+	handlerStatusOk    = "ok"
+	handlerStatusError = "error"
 )
 
-func connectHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args interface{}) (err error) {
+// Number of the channel messages returned if the history request does not limit them.
+const defaultChannelHistoryLimit = 50
 
-	//region parse args
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Sprintf("unable to parse args: %+v", args)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+var (
+	errClientNotAuthenticated = errors.New("client is not authenticated")
+)
+
+func connectHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *ConnectArgs) (err error) {
+
+	//region authenticate user
+	err = authenticateClient(client, args.Key)
+
+	if errors.Is(err, errTooManyUserConnections) {
+		client.disconnect(websocket.ClosePolicyViolation, err.Error())
+		return err
+	}
+
+	// The cause is logged only, the client gets the generic error.
+	if errors.Is(err, errAuthTokenMissing) || errors.Is(err, errAuthTokenInvalid) || errors.Is(err, errAuthTokenExpired) || errors.Is(err, sql.ErrNoRows) {
+		return client.sendErrorResponse(websocketMessage, ErrorCodeNotAuthenticated, err)
 	}
-	//endregion parse args
 
-	//region validate user
-	userId, err := getUserId(m)
 	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, fmt.Errorf("unable to authenticate client, %s", err.Error()))
 	}
-	//endregion validate user
+	//endregion authenticate user
 
-	//region authenticate user
-	err = registerSender(client, userId)
+	//region resume session
+	// Repeated handshake on the same connection releases the current session.
+	client.detachSession(time.Now())
+	client.setSession(nil)
+
+	if args.SessionToken != "" {
+		err = client.resumeSession(websocketMessage, args.SessionToken, args.LastSeq)
+		if err == nil {
+			// The messages sent meanwhile have been replayed within the session.
+			client.server.markPrivateChannelsDelivered(client.user.Id)
+			return nil
+		}
+		log.Printf("unable to resume the session, client: {%s}, user: {%s}: %s", client.Id, client.user.Id, err.Error())
+	}
+	//endregion resume session
+
+	//region start session
+	session, err := client.startSession()
 
 	if err != nil {
-		err := fmt.Sprintf("client is not authorized, %s", err.Error())
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeInternal, fmt.Errorf("unable to start session, %s", err.Error()))
 	}
-	//endregion authenticate user
+	//endregion start session
 
 	//region response
 	result := WebsocketPayload{
-		Status: handlerStatusOk,
-		Sender: client.user,
+		Status:  handlerStatusOk,
+		Sender:  client.user,
+		Session: session,
 	}
 	err = client.sendResponseMessage(websocketMessage, result)
 	//endregion response
 
+	//region private channel backlog
+	client.sendPrivateChannelBacklog()
+	//endregion private channel backlog
+
 	return err
 }
 
-func userChangeNameHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args interface{}) (err error) {
-	//region parse args
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Sprintf("unable to parse args: %+v", args)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+func pingHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *PingArgs) (err error) {
+	result := WebsocketPayload{
+		Status: handlerStatusOk,
+		Time: &WebsocketTimeInfo{
+			ClientTime: args.ClientTime,
+			ServerTime: time.Now().UnixMilli(),
+			Rtt:        float64(client.getRtt()) / float64(time.Millisecond),
+		},
 	}
-	//endregion parse args
+	return client.sendResponseMessage(websocketMessage, result)
+}
+
+func userChangeNameHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, _ *UserChangeNameArgs) (err error) {
+	//region reload user
+	err = registerSender(client, client.identity.UserId)
 
-	//region validate user
-	userId, err := getUserId(m)
 	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, fmt.Errorf("unable to reload user, %s", err.Error()))
 	}
-	//endregion validate user
+	//endregion reload user
 
-	//region authenticate user
-	err = registerSender(client, userId)
+	//region response
+	result := WebsocketPayload{
+		Status: handlerStatusOk,
+		Sender: client.user,
+	}
+	err = client.sendResponseMessage(websocketMessage, result)
+	//endregion response
+
+	return err
+}
+
+func presenceUpdateHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *PresenceUpdateArgs) (err error) {
+
+	//region update user presence
+	presence := args.Presence
 
+	err = client.updatePresence(presence.Status, presence.SpaceId, presence.ServerId)
 	if err != nil {
-		err := fmt.Sprintf("client is not authorized, %s", err.Error())
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 	}
-	//endregion authenticate user
+
+	err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
+	if err != nil {
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
+	}
+	//endregion update user presence
 
 	//region response
 	result := WebsocketPayload{
@@ -103,223 +143,463 @@ func userChangeNameHandler(client *WebsocketClient, websocketMessage *WebsocketM
 	return err
 }
 
-func presenceUpdateHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args interface{}) (err error) {
+// Handles received chat message requests.
+func channelMessageHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *ChannelSendArgs) (err error) {
 
-	//region parse args
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Sprintf("unable to parse args: %+v", args)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
-	//endregion parse args
+	//region validate channel subscription
+	channelId := args.ChannelId
 
-	//region validate user
-	if client == nil {
-		err := fmt.Errorf("invalid client")
-		log.Errorf("invalid client")
-		return err
+	if bSubscribed := client.isSubscribed(channelId); !bSubscribed {
+		err := fmt.Errorf("client tries to send to channel it is not subscribed to: client: %s, channelId: %s", client.Id, channelId)
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeNotSubscribed, err, map[string]interface{}{"channelId": channelId.String()})
 	}
+	//endregion validate channel subscription
 
-	if client.user == nil {
-		err := fmt.Sprintf("client not authenticated")
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
-	//endregion validate user
+	//region store message
+	channel, _ := client.server.channels.get(channelId)
 
-	//region decode presence JSON
-	jsonBody, err := json.Marshal(m[handlerArgsPresence])
+	chatMessage, err := client.server.store.AddChatMessage(models.ChatMessage{
+		UserId:          client.user.Id,
+		Message:         args.Message,
+		ChannelId:       channelId.String(),
+		ChannelName:     channel.Name,
+		ChannelCategory: channel.Category,
+	})
+	// The message is not broadcast unless it is stored, so it can be edited, deleted and read from the history.
 	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, fmt.Errorf("unable to store message of user {%s} to channel {%s}, %s", client.user.Id, channelId, err.Error()))
 	}
+	//endregion
 
-	var presence models.Presence
+	//region response
+	payload := WebsocketPayload{
+		Status:    handlerStatusOk,
+		Message:   args.Message,
+		MessageId: chatMessage.Id,
+		Sender:    client.user,
+		ChannelId: channelId.String(),
+		Category:  channel.Category,
+	}
 
-	err = json.Unmarshal(jsonBody, &presence)
-	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+	result := WebsocketPayload{Status: handlerStatusOk, MessageId: chatMessage.Id}
+	err = client.sendResponseMessage(websocketMessage, result)
+	//endregion response
+
+	//region broadcast
+	client.server.broadcastMessageToChannel(channelId, payload)
+	//endregion broadcast
+
+	return err
+}
+
+func channelHistoryHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *ChannelHistoryArgs) (err error) {
+
+	//region validate channel subscription
+	channelId := args.ChannelId
+
+	if bSubscribed := client.isSubscribed(channelId); !bSubscribed {
+		err := fmt.Errorf("client tries to read channel it is not subscribed to: client: %s, channelId: %s", client.Id, channelId)
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeNotSubscribed, err, map[string]interface{}{"channelId": channelId.String()})
 	}
-	//endregion decode presence JSON
+	//endregion validate channel subscription
 
-	//region validate status
-	status := presence.Status
-	if !(status == PresenceStatusOffline || status == PresenceStatusAway || status == PresenceStatusAvailable || status == PresenceStatusPlaying) {
-		err := fmt.Sprintf("unknown status, %s", status)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+	//region read messages
+	query := models.ChatMessageQuery{
+		ChannelId: channelId.String(),
+		Limit:     args.Limit,
 	}
-	//endregion validate status
 
-	//region update user presence
-	err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, presence.Status, presence.SpaceId, presence.ServerId)
-	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+	if query.Limit == 0 {
+		query.Limit = defaultChannelHistoryLimit
+	}
+	if args.Before != uuid.Nil {
+		query.BeforeId = args.Before.String()
+	}
+	if args.After != uuid.Nil {
+		query.AfterId = args.After.String()
+	}
+	if args.BeforeTime > 0 {
+		query.Before = time.UnixMilli(args.BeforeTime)
+	}
+	if args.AfterTime > 0 {
+		query.After = time.UnixMilli(args.AfterTime)
 	}
 
-	err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
+	history, err := client.server.getChannelHistory(query)
 	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		log.Errorf("got an error reading history of channel {%s}: %s", channelId, err.Error())
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 	}
-	//endregion update user presence
+	//endregion read messages
 
 	//region response
 	result := WebsocketPayload{
-		Status: handlerStatusOk,
-		Sender: client.user,
+		Status:    handlerStatusOk,
+		ChannelId: channelId.String(),
+		Category:  client.server.channels.getCategory(channelId),
+		History:   history,
 	}
-	err = client.sendResponseMessage(websocketMessage, result)
+	return client.sendResponseMessage(websocketMessage, result)
 	//endregion response
+}
 
-	return err
+// Reads the page of the channel messages with their senders.
+func (server *WebsocketServer) getChannelHistory(query models.ChatMessageQuery) (*WebsocketHistory, error) {
+	messages, hasMore, err := server.store.GetChatMessages(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.newWebsocketHistory(messages, hasMore), nil
 }
 
-// Handles received chat message requests.
-func channelMessageHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args interface{}) (err error) {
-
-	//region parse args
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Sprintf("unable to parse args: %+v", args)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+// Converts the chat messages to the history page, resolving the senders.
+func (server *WebsocketServer) newWebsocketHistory(messages []models.ChatMessage, hasMore bool) *WebsocketHistory {
+	history := &WebsocketHistory{
+		Messages: make([]WebsocketHistoryMessage, 0, len(messages)),
+		HasMore:  hasMore,
+	}
+
+	senders := make(map[uuid.UUID]*models.User)
+	for _, m := range messages {
+		sender, ok := senders[m.UserId]
+		if !ok {
+			var err error
+			if sender, err = server.store.GetUserById(m.UserId); err != nil {
+				// Keep the messages of the users which no longer exist.
+				sender = &models.User{Id: m.UserId}
+			}
+			senders[m.UserId] = sender
+		}
+
+		history.Messages = append(history.Messages, WebsocketHistoryMessage{
+			Id:        m.Id,
+			Message:   m.Message,
+			Sender:    sender,
+			ChannelId: m.ChannelId,
+			Category:  m.ChannelCategory,
+			CreatedAt: m.CreatedAt.UnixMilli(),
+			EditedAt:  getUnixMilli(m.EditedAt),
+			Deleted:   !m.DeletedAt.IsZero(),
+		})
 	}
-	//endregion parse args
 
-	//region validate user
-	userId, err := getUserId(m)
+	return history
+}
+
+func messageEditHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *MessageEditArgs) (err error) {
+
+	//region edit message
+	chatMessage, err := client.server.store.EditChatMessage(args.MessageId.String(), client.getMessageAuthorCondition(), client.user.Id, args.Message)
 	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendMessageChangeError(websocketMessage, args.MessageId, err)
 	}
+	//endregion edit message
 
-	if client.user == nil || client.user.Id != userId {
-		err := fmt.Sprintf("client is not authorized")
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
-	//endregion authenticate user
+	payload := client.server.notifyMessageChanged(client.user, chatMessage)
 
-	//region validate channel subscription
-	channelId, err := getChannelId(m)
+	return client.sendResponseMessage(websocketMessage, payload)
+}
+
+func messageDeleteHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *MessageDeleteArgs) (err error) {
+
+	//region delete message
+	chatMessage, err := client.server.store.DeleteChatMessage(args.MessageId.String(), client.getMessageAuthorCondition(), client.user.Id)
 	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendMessageChangeError(websocketMessage, args.MessageId, err)
 	}
+	//endregion delete message
 
-	if bSubscribed := containsUUID(client.channels, *channelId); !bSubscribed {
-		err := fmt.Sprintf("client tries to send to channel it is not subscribed to: client: %s, channelId: %s", client.Id, channelId)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+	payload := client.server.notifyMessageChanged(client.user, chatMessage)
+
+	return client.sendResponseMessage(websocketMessage, payload)
+}
+
+// Gets the author whose messages the client may change, or nil if it is a moderator who may change any message.
+func (client *WebsocketClient) getMessageAuthorCondition() uuid.UUID {
+	if client.identity.HasRole(RoleModerator) {
+		return uuid.Nil
 	}
-	//endregion validate channel subscription
+	return client.user.Id
+}
 
-	//region validate message
-	if msg := m[handlerArgsMessage]; msg == nil {
-		err := fmt.Sprintf("client tries to send an empty message")
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+// Responds with the error of the message edit or delete request.
+func (client *WebsocketClient) sendMessageChangeError(websocketMessage *WebsocketMessage, messageId uuid.UUID, err error) error {
+	details := map[string]interface{}{"messageId": messageId.String()}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The message of another user is reported as missing to the client which is not a moderator.
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeNotFound, fmt.Errorf("message {%s} does not exist or has been sent by another user", messageId), details)
+	default:
+		log.Errorf("got an error changing message {%s}: %s", messageId, err.Error())
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeUpstreamUnavailable, err, details)
 	}
-	//endregion validate message
+}
 
-	//region store message
-	var channelName = ""
+func groupCreateHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *GroupCreateArgs) (err error) {
 
-	server, err := models.GetServerById(WebsocketServerInstance.Db, *channelId)
-	if err == nil {
-		channelName = fmt.Sprintf("%s:%d", server.Host, server.Port)
-	} else {
-		space, err := models.GetSpaceById(WebsocketServerInstance.Db, *channelId)
-		if err == nil {
-			channelName = space.Name
+	//region store group
+	channel := models.GroupChannel{
+		Id:        uuid.New(),
+		Name:      args.Name,
+		OwnerId:   client.user.Id,
+		MemberIds: []uuid.UUID{client.user.Id},
+	}
+
+	if err = client.server.store.AddGroupChannel(channel); err != nil {
+		return client.sendGroupError(websocketMessage, channel.Id, err)
+	}
+	//endregion store group
+
+	//region subscription
+	client.server.addGroupChannel(channel.Id, channel.Name)
+	client.addChannelSubscription(channel.Id)
+	//endregion subscription
+
+	//region response
+	result := WebsocketPayload{
+		Status:    handlerStatusOk,
+		ChannelId: channel.Id.String(),
+		Category:  CategoryGroup,
+		Group:     newWebsocketGroup(&channel, nil),
+	}
+	return client.sendResponseMessage(websocketMessage, result)
+	//endregion response
+}
+
+func groupInviteHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *GroupInviteArgs) (err error) {
+	channelId := args.ChannelId
+
+	//region validate owner
+	channel, err := client.server.getGroupChannel(channelId)
+	if err == nil && channel == nil {
+		err = sql.ErrNoRows
+	}
+	if err == nil && channel.OwnerId != client.user.Id {
+		err = errGroupOwnerRequired
+	}
+	if err != nil {
+		return client.sendGroupError(websocketMessage, channelId, err)
+	}
+	//endregion validate owner
+
+	//region add member
+	user, err := client.server.store.GetUserById(args.UserId)
+	if err != nil {
+		err = fmt.Errorf("user {%s} does not exist", args.UserId)
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeNotFound, err, map[string]interface{}{"userId": args.UserId.String()})
+	}
+
+	if channel.HasMember(user.Id) {
+		result := WebsocketPayload{
+			Status:    handlerStatusOk,
+			ChannelId: channelId.String(),
+			Category:  CategoryGroup,
+			Group:     newWebsocketGroup(channel, user),
 		}
+		return client.sendResponseMessage(websocketMessage, result)
 	}
 
-	_ = models.AddChatMessage(WebsocketServerInstance.Db, models.ChatMessage{
-		UserId:          client.user.Id,
-		Message:         m[handlerArgsMessage].(string),
-		ChannelId:       channelId.String(),
-		ChannelName:     channelName,
-		ChannelCategory: getCategoryByChannelId(channelId),
-	})
-	//endregion
+	if err = client.server.store.AddGroupChannelMember(channelId, user.Id, client.server.chat.MaxGroupMembers); err != nil {
+		return client.sendGroupError(websocketMessage, channelId, err)
+	}
+
+	channel.MemberIds = append(channel.MemberIds, user.Id)
+	//endregion add member
 
 	//region response
 	payload := WebsocketPayload{
 		Status:    handlerStatusOk,
-		Message:   m[handlerArgsMessage].(string),
+		Message:   MessageNotifyGroupMemberAdded,
 		Sender:    client.user,
 		ChannelId: channelId.String(),
-		Category:  getCategoryByChannelId(channelId),
+		Category:  CategoryGroup,
+		Group:     newWebsocketGroup(channel, user),
 	}
 
-	result := WebsocketPayload{Status: handlerStatusOk}
+	result := payload
+	result.Message = ""
 	err = client.sendResponseMessage(websocketMessage, result)
 	//endregion response
 
-	//region broadcast
-	broadcastMessageToChannel(*channelId, payload)
-	//endregion broadcast
+	//region notify
+	client.server.broadcastMessageToChannel(channelId, payload)
+
+	// The invited user subscribes to the group channel once notified.
+	payload.Message = MessageNotifyGroupInvited
+	client.server.multicastMessageToChannel([]uuid.UUID{user.Id}, client.server.SystemChannel, payload)
+	//endregion notify
 
 	return err
 }
 
-func channelSubscribeHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args interface{}) (err error) {
+func groupKickHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *GroupKickArgs) (err error) {
+	channelId := args.ChannelId
 
-	//region parse args
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Sprintf("unable to parse args: %+v", args)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+	if args.UserId == client.user.Id {
+		err = fmt.Errorf("can not kick self from the group, leave it instead")
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeInvalidArgs, err, map[string]interface{}{"arg": "userId"})
 	}
-	//endregion parse args
 
-	//region validate user
-	userId, err := getUserId(m)
+	//region validate owner
+	channel, err := client.server.getGroupChannel(channelId)
+	if err == nil && channel == nil {
+		err = sql.ErrNoRows
+	}
+	if err == nil && channel.OwnerId != client.user.Id {
+		err = errGroupOwnerRequired
+	}
 	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendGroupError(websocketMessage, channelId, err)
 	}
+	//endregion validate owner
 
-	if client.user == nil || client.user.Id != userId {
-		err := fmt.Sprintf("client is not authorized")
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
+	//region remove member
+	channel, err = client.server.store.RemoveGroupChannelMember(channelId, args.UserId)
+	if err != nil {
+		return client.sendGroupError(websocketMessage, channelId, err)
 	}
-	//endregion validate user
 
-	// Get the channel the message is sent to.
-	channelId, err := getChannelId(m)
+	user, err := client.server.store.GetUserById(args.UserId)
 	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		// Keep the membership of the users which no longer exist removable.
+		user = &models.User{Id: args.UserId}
+	}
+
+	client.server.removeGroupMember(channelId, channel, user, client.user)
+	//endregion remove member
+
+	//region notify kicked user
+	payload := WebsocketPayload{
+		Status:    handlerStatusOk,
+		Message:   MessageNotifyGroupKicked,
+		Sender:    client.user,
+		ChannelId: channelId.String(),
+		Category:  CategoryGroup,
+	}
+	// The channel may have been deleted meanwhile by the owner leaving it.
+	if channel != nil {
+		payload.Group = newWebsocketGroup(channel, user)
+	}
+	client.server.multicastMessageToChannel([]uuid.UUID{user.Id}, client.server.SystemChannel, payload)
+	//endregion notify kicked user
+
+	//region response
+	result := payload
+	result.Message = ""
+	return client.sendResponseMessage(websocketMessage, result)
+	//endregion response
+}
+
+func groupLeaveHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *GroupLeaveArgs) (err error) {
+	channelId := args.ChannelId
+
+	//region remove member
+	channel, err := client.server.store.RemoveGroupChannelMember(channelId, client.user.Id)
+	if err != nil {
+		return client.sendGroupError(websocketMessage, channelId, err)
+	}
+
+	client.server.removeGroupMember(channelId, channel, client.user, client.user)
+	//endregion remove member
+
+	//region response
+	result := WebsocketPayload{
+		Status:    handlerStatusOk,
+		ChannelId: channelId.String(),
+		Category:  CategoryGroup,
+	}
+	return client.sendResponseMessage(websocketMessage, result)
+	//endregion response
+}
+
+func groupListHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, _ *GroupListArgs) (err error) {
+	channels, err := client.server.store.GetGroupChannelsByUserId(client.user.Id)
+	if err != nil {
+		log.Errorf("got an error getting group channels of user {%s}: %s", client.user.Id, err.Error())
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
+	}
+
+	groups := make([]WebsocketGroup, 0, len(channels))
+	for i := range channels {
+		client.server.addGroupChannel(channels[i].Id, channels[i].Name)
+		groups = append(groups, *newWebsocketGroup(&channels[i], nil))
+	}
+
+	result := WebsocketPayload{
+		Status: handlerStatusOk,
+		Groups: groups,
+	}
+	return client.sendResponseMessage(websocketMessage, result)
+}
+
+func channelSubscribeHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *ChannelSubscribeArgs) (err error) {
+
+	//region open a private channel with the user
+	if args.UserId != uuid.Nil {
+		if args.ChannelId != uuid.Nil {
+			err = fmt.Errorf("either the channel id or the user id must be set, not both")
+			return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeInvalidArgs, err, map[string]interface{}{"arg": "userId"})
+		}
+
+		otherUser := client.server.findPrivateChannelGuest(args.UserId)
+		if otherUser == nil {
+			err = fmt.Errorf("user {%s} does not exist", args.UserId)
+			return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeNotFound, err, map[string]interface{}{"userId": args.UserId.String()})
+		}
+
+		if otherUser.Id == client.user.Id {
+			err = fmt.Errorf("can not subscribe user to self, %s", otherUser.Id)
+			return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeInvalidArgs, err, map[string]interface{}{"arg": "userId"})
+		}
+
+		newChannelId := getPrivateChannelId(client.user.Id, otherUser.Id)
+
+		// Store the channel, so the guest receives the messages on the next connect if offline.
+		err = client.server.store.AddPrivateChannel(models.PrivateChannel{Id: newChannelId, HostId: client.user.Id, GuestId: otherUser.Id})
+		if err != nil {
+			log.Errorf("got an error storing private channel {%s}: %s", newChannelId, err.Error())
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
+		}
+
+		// Register host and guest users with the private channel and subscribe all their devices. The devices
+		// connected to another instance are subscribed by that instance.
+		client.server.joinPrivateChannel(newChannelId, client.user.Id, otherUser.Id)
+		client.server.publish(&BackplaneEvent{
+			Kind:      BackplanePrivateChannel,
+			ChannelId: newChannelId,
+			UserIds:   []uuid.UUID{client.user.Id, otherUser.Id},
+		})
+
+		result := WebsocketPayload{
+			Status:    handlerStatusOk,
+			ChannelId: newChannelId.String(),
+			Category:  CategoryPrivate,
+			History:   client.server.getChannelBackfill(newChannelId, args.History),
+		}
+
+		err = client.sendResponseMessage(websocketMessage, result)
+
+		// Notify that user joined channel.
+		client.server.notifyUserJoinedChannel(newChannelId, client.user)
+		client.server.notifyUserJoinedChannel(newChannelId, otherUser)
+
+		return err
+	}
+	//endregion open a private channel with the user
+
+	// Get the channel the message is sent to.
+	channelId := args.ChannelId
+
+	if channelId == uuid.Nil {
+		err = fmt.Errorf("either the channel id or the user id is required")
+		return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeInvalidArgs, err, map[string]interface{}{"arg": "channelId"})
 	}
 
 	//region subscribe to the system channel
-	if WebsocketServerInstance.SystemChannel == *channelId {
-		client.addChannelSubscription(*channelId)
+	if client.server.SystemChannel == channelId {
+		client.addChannelSubscription(channelId)
 
 		result := WebsocketPayload{
 			Status:    handlerStatusOk,
@@ -328,169 +608,149 @@ func channelSubscribeHandler(client *WebsocketClient, websocketMessage *Websocke
 		}
 		err = client.sendResponseMessage(websocketMessage, result)
 
-		notifyUserJoinedChannel(*channelId, client.user)
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 
-		err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusAvailable, uuid.Nil, uuid.Nil)
+		err = client.updatePresence(PresenceStatusAvailable, uuid.Nil, uuid.Nil)
 		if err != nil {
-			log.Errorf(err.Error())
-			result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-			return client.sendResponseMessage(websocketMessage, result)
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
 
-		err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
 
 		return err
 	}
 	//endregion subscribe to the system channel
 
 	//region subscribe to the global channel
-	if WebsocketServerInstance.GeneralChannel == *channelId {
-		client.addChannelSubscription(*channelId)
+	if client.server.GeneralChannel == channelId {
+		client.addChannelSubscription(channelId)
 
 		result := WebsocketPayload{
 			Status:    handlerStatusOk,
 			ChannelId: channelId.String(),
 			Category:  CategoryGeneral,
+			History:   client.server.getChannelBackfill(channelId, args.History),
 		}
 		err = client.sendResponseMessage(websocketMessage, result)
 
-		notifyUserJoinedChannel(*channelId, client.user)
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 
 		return err
 	}
 	//endregion subscribe to the global channel
 
 	//region subscribe to a cached space channel
-	for _, c := range WebsocketServerInstance.SpaceChannels {
-		if c == *channelId {
-			client.addChannelSubscription(*channelId)
-
-			result := WebsocketPayload{
-				Status:    handlerStatusOk,
-				ChannelId: channelId.String(),
-				Category:  CategorySpace,
-			}
-			err = client.sendResponseMessage(websocketMessage, result)
-
-			notifyUserJoinedChannel(*channelId, client.user)
+	if client.server.isSpaceChannel(channelId) {
+		client.addChannelSubscription(channelId)
 
-			err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusAvailable, *channelId, client.user.Presence.ServerId)
-			if err != nil {
-				log.Errorf(err.Error())
-				result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-				return client.sendResponseMessage(websocketMessage, result)
-			}
+		result := WebsocketPayload{
+			Status:    handlerStatusOk,
+			ChannelId: channelId.String(),
+			Category:  CategorySpace,
+			History:   client.server.getChannelBackfill(channelId, args.History),
+		}
+		err = client.sendResponseMessage(websocketMessage, result)
 
-			err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 
-			return err
+		err = client.updatePresence(PresenceStatusAvailable, channelId, client.presence.ServerId)
+		if err != nil {
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
+
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
+
+		return err
 	}
 	//endregion subscribe to a cached space channel
 
 	//region subscribe to a cached server channel
-	for _, c := range WebsocketServerInstance.ServerChannels {
-		if c == *channelId {
-			client.addChannelSubscription(*channelId)
-
-			result := WebsocketPayload{
-				Status:    handlerStatusOk,
-				ChannelId: channelId.String(),
-				Category:  CategoryServer,
-			}
-			err = client.sendResponseMessage(websocketMessage, result)
-
-			notifyUserJoinedChannel(*channelId, client.user)
+	if client.server.isServerChannel(channelId) {
+		client.addChannelSubscription(channelId)
 
-			err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusAvailable, client.user.Presence.SpaceId, *channelId)
-			if err != nil {
-				log.Errorf(err.Error())
-				result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-				return client.sendResponseMessage(websocketMessage, result)
-			}
+		result := WebsocketPayload{
+			Status:    handlerStatusOk,
+			ChannelId: channelId.String(),
+			Category:  CategoryServer,
+			History:   client.server.getChannelBackfill(channelId, args.History),
+		}
+		err = client.sendResponseMessage(websocketMessage, result)
 
-			err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 
-			return err
+		err = client.updatePresence(PresenceStatusAvailable, client.presence.SpaceId, channelId)
+		if err != nil {
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
+
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
+
+		return err
 	}
 	//endregion subscribe to a cached server channel
 
 	//region subscribe to a private channel
-	for _, otherClient := range WebsocketServerInstance.Clients {
-
-		if otherClient.user == nil {
-			continue
-		}
-
-		if otherClient.user.Id == *channelId {
+	members, err := client.server.findPrivateChannelMembers(channelId, client.user.Id)
+	if err != nil {
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
+	}
 
-			if otherClient.user.Id == client.user.Id {
-				err = fmt.Errorf("can not subscribe user to self, %s", otherClient.user.Id)
-				log.Errorf(err.Error())
-				result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-				return client.sendResponseMessage(websocketMessage, result)
-			}
+	if members != nil {
+		client.server.addPrivateChannel(channelId, members[0], members[1])
+		client.addChannelSubscription(channelId)
 
-			var foundChannelId = findExistingPrivateChannelForUsers(client.user.Id, otherClient.user.Id)
-			var newChannelId uuid.UUID
+		result := WebsocketPayload{
+			Status:    handlerStatusOk,
+			ChannelId: channelId.String(),
+			Category:  CategoryPrivate,
+			History:   client.server.getChannelBackfill(channelId, args.History),
+		}
+		err = client.sendResponseMessage(websocketMessage, result)
 
-			if foundChannelId == nil {
-				newChannelId, err = uuid.NewUUID()
-			} else {
-				newChannelId = *foundChannelId
-			}
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 
-			if err != nil {
-				log.Errorf(err.Error())
-				result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-				return client.sendResponseMessage(websocketMessage, result)
-			}
+		return err
+	}
+	//endregion subscribe to a private channel
 
-			// Register host and guest users with the private channel.
-			WebsocketServerInstance.PrivateChannels[newChannelId] = PrivateChannelInfo{client.user.Id, otherClient.user.Id}
+	//region subscribe to a group channel
+	group, err := client.server.getGroupChannel(channelId)
+	if err != nil {
+		return client.sendGroupError(websocketMessage, channelId, err)
+	}
 
-			// Subscribe both host and guest users to the new channel.
-			client.addChannelSubscription(newChannelId)
-			otherClient.addChannelSubscription(newChannelId)
+	if group != nil {
+		if !group.HasMember(client.user.Id) {
+			return client.sendGroupError(websocketMessage, channelId, errGroupMemberRequired)
+		}
 
-			result := WebsocketPayload{
-				Status:    handlerStatusOk,
-				ChannelId: newChannelId.String(),
-				Category:  CategoryPrivate,
-			}
+		client.addChannelSubscription(channelId)
 
-			err = client.sendResponseMessage(websocketMessage, result)
+		result := WebsocketPayload{
+			Status:    handlerStatusOk,
+			ChannelId: channelId.String(),
+			Category:  CategoryGroup,
+			History:   client.server.getChannelBackfill(channelId, args.History),
+			Group:     newWebsocketGroup(group, nil),
+		}
+		err = client.sendResponseMessage(websocketMessage, result)
 
-			// Notify that user joined channel.
-			notifyUserJoinedChannel(newChannelId, client.user)
-			notifyUserJoinedChannel(newChannelId, otherClient.user)
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 
-			return err
-		}
+		return err
 	}
-	//endregion subscribe to a private channel
+	//endregion subscribe to a group channel
 
 	//region subscribe to a non-cached space channel
-	space, err := models.GetSpaceById(WebsocketServerInstance.Db, *channelId)
+	space, err := client.server.store.GetSpaceById(channelId)
 	if space != nil {
 
-		//region server channel cache
-		var exists = false
-		for _, v := range WebsocketServerInstance.ServerChannels {
-			if v == space.Id {
-				exists = true
-				break
-			}
-		}
-
-		if !exists {
-			WebsocketServerInstance.SpaceChannels = append(WebsocketServerInstance.SpaceChannels, space.Id)
-		}
-		//endregion server channel cache
+		//region space channel cache
+		client.server.addSpaceChannel(space.Id, space.Name)
+		//endregion space channel cache
 
 		//region subscription
-		client.addChannelSubscription(*channelId)
+		client.addChannelSubscription(channelId)
 		//endregion subscription
 
 		//region response
@@ -498,23 +758,22 @@ func channelSubscribeHandler(client *WebsocketClient, websocketMessage *Websocke
 			Status:    handlerStatusOk,
 			ChannelId: channelId.String(),
 			Category:  CategorySpace,
+			History:   client.server.getChannelBackfill(channelId, args.History),
 		}
 		err = client.sendResponseMessage(websocketMessage, result)
 		//endregion response
 
 		//region notify
-		notifyUserJoinedChannel(*channelId, client.user)
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 		//endregion notify
 
 		//region presence
-		err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusAvailable, *channelId, client.user.Presence.ServerId)
+		err = client.updatePresence(PresenceStatusAvailable, channelId, client.presence.ServerId)
 		if err != nil {
-			log.Errorf(err.Error())
-			result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-			return client.sendResponseMessage(websocketMessage, result)
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
 
-		err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
 		//endregion presence
 
 		return err
@@ -522,24 +781,14 @@ func channelSubscribeHandler(client *WebsocketClient, websocketMessage *Websocke
 	//endregion subscribe to a non-cached space channel
 
 	//region subscribe to a non-cached server channel
-	server, err := models.GetServerById(WebsocketServerInstance.Db, *channelId)
+	server, err := client.server.store.GetServerById(channelId)
 	if server != nil {
 		//region server channel cache
-		var exists = false
-		for _, v := range WebsocketServerInstance.ServerChannels {
-			if v == server.Id {
-				exists = true
-				break
-			}
-		}
-
-		if !exists {
-			WebsocketServerInstance.ServerChannels = append(WebsocketServerInstance.ServerChannels, server.Id)
-		}
+		client.server.addServerChannel(server.Id, fmt.Sprintf("%s:%d", server.Host, server.Port))
 		//endregion server channel cache
 
 		//region subscribe
-		client.addChannelSubscription(*channelId)
+		client.addChannelSubscription(channelId)
 		//endregion subscribe
 
 		//region response
@@ -547,24 +796,23 @@ func channelSubscribeHandler(client *WebsocketClient, websocketMessage *Websocke
 			Status:    handlerStatusOk,
 			ChannelId: channelId.String(),
 			Category:  CategoryServer,
+			History:   client.server.getChannelBackfill(channelId, args.History),
 		}
 
 		err = client.sendResponseMessage(websocketMessage, result)
 		//endregion response
 
 		//region notify
-		notifyUserJoinedChannel(*channelId, client.user)
+		client.server.notifyUserJoinedChannel(channelId, client.user)
 		//endregion notify
 
 		//region presence
-		err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusAvailable, client.user.Presence.SpaceId, *channelId)
+		err = client.updatePresence(PresenceStatusAvailable, client.presence.SpaceId, channelId)
 		if err != nil {
-			log.Errorf(err.Error())
-			result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-			return client.sendResponseMessage(websocketMessage, result)
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
 
-		err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
 		//endregion presence
 
 		return err
@@ -573,106 +821,47 @@ func channelSubscribeHandler(client *WebsocketClient, websocketMessage *Websocke
 
 	// Disallow to join arbitrary channel.
 	err = fmt.Errorf("channel {%s} does not exist", channelId)
-	log.Errorf(err.Error())
-	result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-	return client.sendResponseMessage(websocketMessage, result)
+	return client.sendErrorResponse(websocketMessage, ErrorCodeNotFound, err)
 }
 
-func channelUnsubscribeHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args interface{}) (err error) {
-
-	// Parse request args as the string key map.
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Sprintf("unable to parse args: %+v", args)
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
-
-	userId, err := getUserId(m)
-	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
-
-	if client.user == nil || client.user.Id != userId {
-		err := fmt.Sprintf("client is not authorized")
-		log.Errorf(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
+func channelUnsubscribeHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *ChannelUnsubscribeArgs) (err error) {
 
 	// Get the channel the message is sent to.
-	channelId, err := getChannelId(m)
-	if err != nil {
-		log.Errorf(err.Error())
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
+	channelId := args.ChannelId
 
-	notifyUserLeftChannel(*channelId, client.user)
-	client.removeChannelSubscription(*channelId)
+	client.server.notifyUserLeftChannel(channelId, client.user)
+	client.removeChannelSubscription(channelId)
 
 	//region update user presence
-	if len(client.channels) == 0 || WebsocketServerInstance.SystemChannel == *channelId {
-		err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusOffline, uuid.Nil, uuid.Nil)
+	if len(client.getChannels()) == 0 || client.server.SystemChannel == channelId {
+		err = client.updatePresence(PresenceStatusOffline, uuid.Nil, uuid.Nil)
 		if err != nil {
-			log.Errorf(err.Error())
-			result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-			return client.sendResponseMessage(websocketMessage, result)
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
 
-		err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
 		if err != nil {
-			log.Errorf(err.Error())
-			result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-			return client.sendResponseMessage(websocketMessage, result)
-		}
-	} else {
-		var found = false
-		for _, c := range WebsocketServerInstance.SpaceChannels {
-			if c == *channelId {
-				err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusAvailable, uuid.Nil, client.user.Presence.ServerId)
-				if err != nil {
-					log.Errorf(err.Error())
-					result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-					return client.sendResponseMessage(websocketMessage, result)
-				}
-
-				err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
-				if err != nil {
-					log.Errorf(err.Error())
-					result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-					return client.sendResponseMessage(websocketMessage, result)
-				}
-
-				found = true
-				break
-			}
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
+		}
+	} else if client.server.isSpaceChannel(channelId) {
+		err = client.updatePresence(PresenceStatusAvailable, uuid.Nil, client.presence.ServerId)
+		if err != nil {
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
 
-		if !found {
-			for _, c := range WebsocketServerInstance.ServerChannels {
-				if c == *channelId {
-					err = client.user.UpdateUserPresence(WebsocketServerInstance.Db, PresenceStatusAvailable, client.user.Presence.SpaceId, uuid.Nil)
-					if err != nil {
-						log.Errorf(err.Error())
-						result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-						return client.sendResponseMessage(websocketMessage, result)
-					}
-
-					err = notifyUserPresenceChanged(WebsocketServerInstance.SystemChannel, client.user)
-					if err != nil {
-						log.Errorf(err.Error())
-						result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-						return client.sendResponseMessage(websocketMessage, result)
-					}
-
-					found = true
-					break
-				}
-			}
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
+		if err != nil {
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
+		}
+	} else if client.server.isServerChannel(channelId) {
+		err = client.updatePresence(PresenceStatusAvailable, client.presence.SpaceId, uuid.Nil)
+		if err != nil {
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
+		}
+
+		err = client.server.notifyUserPresenceChanged(client.server.SystemChannel, client.user)
+		if err != nil {
+			return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 		}
 	}
 	//endregion
@@ -680,162 +869,139 @@ func channelUnsubscribeHandler(client *WebsocketClient, websocketMessage *Websoc
 	result := WebsocketPayload{
 		Status:    handlerStatusOk,
 		ChannelId: channelId.String(),
-		Category:  getCategoryByChannelId(channelId),
+		Category:  client.server.getCategoryByChannelId(&channelId),
 	}
 	return client.sendResponseMessage(websocketMessage, result)
 }
 
-func vivoxHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) (err error) {
+func vivoxHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, topic WebsocketTopic, method string, args *VivoxArgs) (err error) {
 	if topic != VivoxTopic {
 		err := fmt.Errorf("wrong topic %d for the method %s", topic, method)
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeInvalidArgs, err)
 	}
 
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Errorf("unable to parse args %s", args)
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
+	tokenPayload := args.VivoxPayload
 
-	userId, err := getUserId(m)
-	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
+	// Tokens are issued on behalf of the authenticated user only.
+	tokenPayload.From = client.user.Id.String()
 
-	if client.user == nil || client.user.Id != userId {
-		err := fmt.Sprintf("client is not authenticated")
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
-
-	jsonBody, err := json.Marshal(m[handlerArgsVivoxPayload])
-	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
-
-	var tokenPayload models.VivoxTokenPayload
-
-	err = json.Unmarshal(jsonBody, &tokenPayload)
-	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+	if method == VivoxMuteMethod || method == VivoxUnmuteMethod || method == VivoxKickMethod {
+		if !client.identity.HasRole(RoleModerator) {
+			err := fmt.Errorf("user {%s} is not a moderator, method: %s", client.user.Id, method)
+			return client.sendErrorResponse(websocketMessage, ErrorCodeForbidden, err)
+		}
 	}
 
 	var jsonPayload string
 
 	if method == VivoxGetLoginTokenMethod {
-		jsonPayload, err = models.GetLoginToken(tokenPayload)
+		jsonPayload, err = client.server.vivox.GetLoginToken(tokenPayload)
 	} else if method == VivoxGetJoinTokenMethod {
-		jsonPayload, err = models.GetJoinToken(tokenPayload)
+		jsonPayload, err = client.server.vivox.GetJoinToken(tokenPayload)
 	} else if method == VivoxMuteMethod {
-		jsonPayload, err = models.RequestMute(tokenPayload)
+		jsonPayload, err = client.server.vivox.RequestMute(tokenPayload)
 	} else if method == VivoxUnmuteMethod {
-		jsonPayload, err = models.RequestUnmute(tokenPayload)
+		jsonPayload, err = client.server.vivox.RequestUnmute(tokenPayload)
 	} else if method == VivoxKickMethod {
-		jsonPayload, err = models.RequestKick(tokenPayload)
+		jsonPayload, err = client.server.vivox.RequestKick(tokenPayload)
 	}
 
 	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 	}
 
 	result := WebsocketPayload{Status: handlerStatusOk, Message: jsonPayload}
 	return client.sendResponseMessage(websocketMessage, result)
 }
 
-func userActionHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args interface{}) (err error) {
-	m, ok := args.(map[string]interface{})
-	if !ok {
-		err := fmt.Errorf("unable to parse args %s", args)
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
+func userActionHandler(client *WebsocketClient, websocketMessage *WebsocketMessage, _ WebsocketTopic, _ string, args *UserActionArgs) (err error) {
+	// Users report their own actions only.
+	action := args.Message
+	action.SenderId = client.user.Id.String()
+	action.UserId = client.user.Id.String()
 
-	userId, err := getUserId(m)
+	err = client.server.store.AddAction(action)
 	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+		return client.sendErrorResponse(websocketMessage, ErrorCodeUpstreamUnavailable, err)
 	}
 
-	if client.user == nil || client.user.Id != userId {
-		err := fmt.Sprintf("client is not authenticated")
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
+	result := WebsocketPayload{Status: handlerStatusOk}
+	return client.sendResponseMessage(websocketMessage, result)
+}
 
-	jsonBody, err := json.Marshal(m[handlerArgsMessage])
-	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
-	}
+//region broadcast and notify helpers
 
-	var action models.Action
+// Sends the message to the channel subscribers connected to any of the server instances.
+func (server *WebsocketServer) broadcastMessageToChannel(channelId uuid.UUID, payload WebsocketPayload) {
+	server.broadcastMessageToLocalChannel(channelId, payload)
 
-	err = json.Unmarshal(jsonBody, &action)
-	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+	server.publish(&BackplaneEvent{
+		Kind:      BackplaneBroadcast,
+		ChannelId: channelId,
+		Payload:   &payload,
+	})
+}
+
+// Sends the message to the channel subscribers connected to any of the server instances if they are listed.
+func (server *WebsocketServer) multicastMessageToChannel(userIds []uuid.UUID, channelId uuid.UUID, payload WebsocketPayload) {
+	if len(userIds) == 0 {
+		return
 	}
 
-	err = models.AddAction(WebsocketServerInstance.Db, action)
-	if err != nil {
-		log.Error(err)
-		result := WebsocketPayload{Status: handlerStatusError, Message: err.Error()}
-		return client.sendResponseMessage(websocketMessage, result)
+	server.multicastMessageToLocalChannel(userIds, channelId, payload)
+
+	server.publish(&BackplaneEvent{
+		Kind:      BackplaneMulticast,
+		ChannelId: channelId,
+		UserIds:   userIds,
+		Payload:   &payload,
+	})
+}
+
+// Sends the message to the channel subscribers connected to this instance.
+func (server *WebsocketServer) broadcastMessageToLocalChannel(channelId uuid.UUID, payload WebsocketPayload) {
+	clients, sessions := server.channels.getSubscribers(channelId)
+
+	for _, client := range clients {
+		if err := client.SendPushMessage(ChatTopic, payload); err != nil {
+			log.Errorf("got an error sending push message to websocket client {%s}", client.Id)
+		}
 	}
 
-	result := WebsocketPayload{Status: handlerStatusOk}
-	return client.sendResponseMessage(websocketMessage, result)
+	server.recordPrivateMessageDelivered(channelId, payload, clients)
+
+	// Keep the message for the clients expected to reconnect.
+	for _, session := range sessions {
+		_ = session.push(newPushMessage(ChatTopic, payload))
+	}
 }
 
-//region broadcast and notify helpers
+// Sends the message to the channel subscribers connected to this instance if they are listed.
+func (server *WebsocketServer) multicastMessageToLocalChannel(userIds []uuid.UUID, channelId uuid.UUID, payload WebsocketPayload) {
+	clients, sessions := server.channels.getSubscribers(channelId)
+
+	recipients := make(map[uuid.UUID]bool, len(userIds))
+	for _, userId := range userIds {
+		recipients[userId] = true
+	}
 
-func broadcastMessageToChannel(channelId uuid.UUID, payload WebsocketPayload) {
-	// Broadcast the message to channels.
-	for _, client := range WebsocketServerInstance.Clients {
-		// Check if client is subscribed for the channel.
-		if bSubscribed := containsUUID(client.channels, channelId); bSubscribed {
-			// Broadcast the message to subscribed clients.
+	for _, client := range clients {
+		if user := client.getUser(); user != nil && recipients[user.Id] {
 			if err := client.SendPushMessage(ChatTopic, payload); err != nil {
 				log.Errorf("got an error sending push message to websocket client {%s}", client.Id)
 			}
 		}
 	}
-}
 
-func multicastMessageToChannel(userIds []uuid.UUID, channelId uuid.UUID, payload WebsocketPayload) {
-	// Broadcast the message to channels.
-	for _, client := range WebsocketServerInstance.Clients {
-		// Check if client is subscribed for the channel.
-		if bSubscribed := containsUUID(client.channels, channelId); bSubscribed {
-			if bShouldSend := containsUUID(userIds, client.user.Id); bShouldSend {
-				// Multicast the message to subscribed clients.
-				if err := client.SendPushMessage(ChatTopic, payload); err != nil {
-					log.Errorf("got an error sending push message to websocket client {%s}", client.Id)
-				}
-			}
+	// Keep the message for the clients expected to reconnect.
+	for _, session := range sessions {
+		if recipients[session.userId] {
+			_ = session.push(newPushMessage(ChatTopic, payload))
 		}
 	}
 }
 
-func notifyUserPresenceChanged(channelId uuid.UUID, user *models.User) (err error) {
+func (server *WebsocketServer) notifyUserPresenceChanged(channelId uuid.UUID, user *models.User) (err error) {
 
 	jsonPayload, err := json.Marshal(user.Presence)
 	if err != nil {
@@ -848,10 +1014,10 @@ func notifyUserPresenceChanged(channelId uuid.UUID, user *models.User) (err erro
 		Message:   string(jsonPayload),
 		Sender:    user,
 		ChannelId: channelId.String(),
-		Category:  getCategoryByChannelId(&channelId),
+		Category:  server.getCategoryByChannelId(&channelId),
 	}
 
-	leaders, err := models.GetUserLeadersById(WebsocketServerInstance.Db, user.Id)
+	leaders, err := server.store.GetUserLeadersById(user.Id)
 	if err != nil {
 		log.Error(err)
 		return err
@@ -862,114 +1028,140 @@ func notifyUserPresenceChanged(channelId uuid.UUID, user *models.User) (err erro
 		userIds = append(userIds, v.Id)
 	}
 
-	multicastMessageToChannel(userIds, channelId, payload)
+	server.multicastMessageToChannel(userIds, channelId, payload)
 
 	return nil
 }
 
-func notifyUserJoinedChannel(channelId uuid.UUID, user *models.User) {
+func (server *WebsocketServer) notifyUserJoinedChannel(channelId uuid.UUID, user *models.User) {
 	payload := WebsocketPayload{
 		Status:    handlerStatusOk,
 		Message:   MessageNotifyUserJoinedChannel,
 		Sender:    user,
 		ChannelId: channelId.String(),
-		Category:  getCategoryByChannelId(&channelId),
+		Category:  server.getCategoryByChannelId(&channelId),
 	}
 
-	broadcastMessageToChannel(channelId, payload)
+	server.broadcastMessageToChannel(channelId, payload)
 }
 
-func notifyUserLeftChannel(channelId uuid.UUID, user *models.User) {
+func (server *WebsocketServer) notifyUserLeftChannel(channelId uuid.UUID, user *models.User) {
 	payload := WebsocketPayload{
 		Status:    handlerStatusOk,
 		Message:   MessageNotifyUserLeftChannel,
 		Sender:    user,
 		ChannelId: channelId.String(),
-		Category:  getCategoryByChannelId(&channelId),
+		Category:  server.getCategoryByChannelId(&channelId),
 	}
 
-	broadcastMessageToChannel(channelId, payload)
+	server.broadcastMessageToChannel(channelId, payload)
 }
 
-//endregion notify helpers
-
-//region get and find helpers
-
-func findExistingPrivateChannelForUsers(userId uuid.UUID, otherUserId uuid.UUID) *uuid.UUID {
-	for privateChannelId, privateChannel := range WebsocketServerInstance.PrivateChannels {
-		if privateChannel.Host == userId && privateChannel.Guest == otherUserId {
-			return &privateChannelId
-		}
-		if privateChannel.Host == otherUserId && privateChannel.Guest == userId {
-			return &privateChannelId
-		}
+// Notifies the channel subscribers that the message has been edited or deleted by the user. Returns the notification.
+func (server *WebsocketServer) notifyMessageChanged(user *models.User, chatMessage *models.ChatMessage) WebsocketPayload {
+	payload := WebsocketPayload{
+		Status:    handlerStatusOk,
+		Message:   MessageNotifyMessageEdited,
+		MessageId: chatMessage.Id,
+		Sender:    user,
+		ChannelId: chatMessage.ChannelId,
+		Category:  chatMessage.ChannelCategory,
+		Update: &WebsocketMessageUpdate{
+			MessageId: chatMessage.Id,
+			Message:   chatMessage.Message,
+			EditedAt:  getUnixMilli(chatMessage.EditedAt),
+			DeletedAt: getUnixMilli(chatMessage.DeletedAt),
+		},
 	}
-	return nil
-}
-
-func getChannelId(args map[string]interface{}) (*uuid.UUID, error) {
 
-	// Get the channel the message is sent to.
-	channelIdStr, ok := args[handlerArgsChannel].(string)
-	if !ok {
-		return nil, fmt.Errorf("can not parse channel id as string: %s", args[handlerArgsChannel])
+	if !chatMessage.DeletedAt.IsZero() {
+		payload.Message = MessageNotifyMessageDeleted
 	}
 
-	// Check that the channel ID is correct.
-	channelId, err := uuid.Parse(channelIdStr)
-	if err != nil {
-		return nil, fmt.Errorf("can not parse channel UUID: %s", channelIdStr)
+	if channelId, err := uuid.Parse(chatMessage.ChannelId); err == nil {
+		server.broadcastMessageToChannel(channelId, payload)
 	}
 
-	return &channelId, nil
+	return payload
 }
 
-func getUserId(args map[string]interface{}) (uuid.UUID, error) {
-
-	userIdStr, ok := args[handlerArgsUserId].(string)
-	if !ok {
-		return uuid.Nil, fmt.Errorf("can not parse user api key as string: %s", args[handlerArgsUserId])
-	}
+//endregion notify helpers
 
-	return uuid.Parse(userIdStr)
-}
+//region get and find helpers
 
-func getCategoryByChannelId(channelId *uuid.UUID) string {
-	if WebsocketServerInstance.SystemChannel == *channelId {
-		return CategorySystem
+// Finds the user to open the private channel with. The user may be offline.
+func (server *WebsocketServer) findPrivateChannelGuest(userId uuid.UUID) *models.User {
+	if clients := server.users.getClients(userId); len(clients) > 0 {
+		return clients[0].getUser()
 	}
 
-	if WebsocketServerInstance.GeneralChannel == *channelId {
-		return CategoryGeneral
+	user, err := server.store.GetUserById(userId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("got an error getting user {%s}: %s", userId, err.Error())
+		}
+		return nil
 	}
 
-	for _, c := range WebsocketServerInstance.SpaceChannels {
-		if c == *channelId {
-			return CategorySpace
+	return user
+}
+
+// Finds the private channel of the user, the host first. Returns nil if the channel is not a private channel of the user.
+func (server *WebsocketServer) findPrivateChannelMembers(channelId uuid.UUID, userId uuid.UUID) ([]uuid.UUID, error) {
+	if info, ok := server.channels.get(channelId); ok && info.Category == CategoryPrivate {
+		if !containsUUID(info.Members, userId) {
+			return nil, nil
 		}
+		return info.Members, nil
 	}
 
-	for _, c := range WebsocketServerInstance.ServerChannels {
-		if c == *channelId {
-			return CategoryServer
-		}
+	// The channel is not registered while none of its members is subscribed on this instance.
+	channels, err := server.store.GetPrivateChannelsByUserId(userId)
+	if err != nil {
+		log.Errorf("got an error getting private channels of user {%s}: %s", userId, err.Error())
+		return nil, err
 	}
 
-	for c := range WebsocketServerInstance.PrivateChannels {
-		if c == *channelId {
-			return CategoryPrivate
+	for _, channel := range channels {
+		if channel.Id == channelId {
+			return []uuid.UUID{channel.HostId, channel.GuestId}, nil
 		}
 	}
 
-	return CategoryUnknown
+	return nil, nil
+}
+
+func (server *WebsocketServer) getCategoryByChannelId(channelId *uuid.UUID) string {
+	return server.channels.getCategory(*channelId)
 }
 
 //endregion getter helpers
 
 //region authentication helpers
 
+func authenticateClient(client *WebsocketClient, token string) error {
+	authenticator := client.server.authenticator
+	if authenticator == nil {
+		return fmt.Errorf("%w: authenticator is not configured", errAuthTokenInvalid)
+	}
+
+	identity, err := authenticator.Authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	if err = registerSender(client, identity.UserId); err != nil {
+		return err
+	}
+
+	client.setIdentity(identity)
+	client.startAuthenticationExpiry(identity)
+
+	return nil
+}
+
 func registerSender(client *WebsocketClient, id uuid.UUID) error {
-	user, err := models.GetUserById(WebsocketServerInstance.Db, id)
+	user, err := client.server.store.GetUserById(id)
 
 	if err != nil {
 		return err
@@ -979,7 +1171,26 @@ func registerSender(client *WebsocketClient, id uuid.UUID) error {
 		return fmt.Errorf("user not found")
 	}
 
-	client.user = user
+	// Group the devices of the user, the repeated handshake may switch the user.
+	var privateChannels []uuid.UUID
+	if previous := client.user; previous == nil || previous.Id != user.Id {
+		privateChannels, err = client.server.users.add(user.Id, client, client.server.admission.settings.MaxConnectionsPerUser)
+		if err != nil {
+			return err
+		}
+
+		privateChannels = append(privateChannels, client.server.restorePrivateChannels(user.Id)...)
+
+		if previous != nil {
+			client.server.users.remove(previous.Id, client)
+		}
+	}
+
+	client.setUser(user)
+
+	for _, channelId := range privateChannels {
+		client.addChannelSubscription(channelId)
+	}
 
 	return nil
 }
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"bytes"
+	"testing"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+)
+
+// Sends the chat message while the store is unavailable. The sender gets the error response and the message is not
+// broadcast to the channel.
+func TestChannelMessageHandlerStoreFailure(t *testing.T) {
+	defer quietTestLog()()
+
+	server := newTestWebsocketServer()
+
+	channelId := uuid.New()
+	server.addSpaceChannel(channelId, "space")
+
+	sender := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+	receiver := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+	sender.addChannelSubscription(channelId)
+	receiver.addChannelSubscription(channelId)
+
+	request := &WebsocketMessage{Id: uuid.New(), Type: RequestMessageType, Topic: ChatTopic, Method: ChannelSendMethod}
+	args := &ChannelSendArgs{ChannelId: channelId, Message: "message"}
+
+	if err := channelMessageHandler(sender, request, ChatTopic, ChannelSendMethod, args); err != nil {
+		t.Fatalf("failed to handle the request: %s", err.Error())
+	}
+
+	responses, _ := sender.send.pop(outboundWriteBatchSize)
+	if len(responses) != 1 || !bytes.Contains(responses[0], []byte(ErrorCodeUpstreamUnavailable)) {
+		t.Errorf("sender got no error response")
+	}
+
+	if messages, _ := receiver.send.pop(outboundWriteBatchSize); len(messages) != 0 {
+		t.Errorf("message has been broadcast, count: {%d}", len(messages))
+	}
+}
+
+// Subscribes to the private channel by its id. The member is subscribed again, while the other user is not.
+func TestChannelSubscribeHandlerPrivateChannel(t *testing.T) {
+	defer quietTestLog()()
+
+	server := newTestWebsocketServer()
+
+	host := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+	guest := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+	stranger := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+
+	channelId := getPrivateChannelId(host.user.Id, guest.user.Id)
+	server.joinPrivateChannel(channelId, host.user.Id, guest.user.Id)
+	host.removeChannelSubscription(channelId)
+
+	for _, client := range []*WebsocketClient{host, stranger} {
+		request := &WebsocketMessage{Id: uuid.New(), Type: RequestMessageType, Topic: ChatTopic, Method: ChannelSubscribeMethod}
+		if err := channelSubscribeHandler(client, request, ChatTopic, ChannelSubscribeMethod, &ChannelSubscribeArgs{ChannelId: channelId}); err != nil {
+			t.Fatalf("failed to handle the request: %s", err.Error())
+		}
+	}
+
+	if !host.isSubscribed(channelId) {
+		t.Errorf("member is not subscribed for the private channel")
+	}
+	if responses, _ := host.send.pop(outboundWriteBatchSize); len(responses) == 0 || !bytes.Contains(responses[0], []byte(CategoryPrivate)) {
+		t.Errorf("member got no private channel response")
+	}
+
+	if stranger.isSubscribed(channelId) {
+		t.Errorf("other user is subscribed for the private channel")
+	}
+}
+
+// Subscribes without the channel id and the user id, or with both of them.
+func TestChannelSubscribeHandlerInvalidArgs(t *testing.T) {
+	defer quietTestLog()()
+
+	server := newTestWebsocketServer()
+	client := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+
+	for _, args := range []*ChannelSubscribeArgs{{}, {ChannelId: uuid.New(), UserId: uuid.New()}} {
+		request := &WebsocketMessage{Id: uuid.New(), Type: RequestMessageType, Topic: ChatTopic, Method: ChannelSubscribeMethod}
+		if err := channelSubscribeHandler(client, request, ChatTopic, ChannelSubscribeMethod, args); err != nil {
+			t.Fatalf("failed to handle the request: %s", err.Error())
+		}
+
+		responses, _ := client.send.pop(outboundWriteBatchSize)
+		if len(responses) != 1 || !bytes.Contains(responses[0], []byte(ErrorCodeInvalidArgs)) {
+			t.Errorf("client got no invalid args response for {%+v}", *args)
+		}
+	}
+}
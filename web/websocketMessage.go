@@ -10,30 +10,33 @@ import (
 
 func (client *WebsocketClient) onMessageReceived(websocketMessage *WebsocketMessage) (err error) {
 
-	log.Printf("received message, client: {%s}, message: {%s}, type: {%d}, , topic: {%d}, method: {%s}, payload: {%v}, args: {%v}", client.Id, websocketMessage.Id, websocketMessage.Type, websocketMessage.Topic, websocketMessage.Method, websocketMessage.Payload, websocketMessage.Args)
-
 	if websocketMessage == nil {
 		return errors.New("received empty pointer instead of websocket message")
 	}
 
+	log.Printf("received message, client: {%s}, message: {%s}, type: {%d}, , topic: {%d}, method: {%s}, payload: {%v}, args: {%v}", client.Id, websocketMessage.Id, websocketMessage.Type, websocketMessage.Topic, websocketMessage.Method, websocketMessage.Payload, websocketMessage.Args)
+
 	switch websocketMessage.Type {
 
 	case PushMessageType:
 
-		// Server does not react to push messages.
+		// Server does not react to push messages other than acknowledgements.
+		if websocketMessage.Topic == SystemTopic && websocketMessage.Method == AckMethod {
+			client.acknowledge(websocketMessage.Seq)
+			break
+		}
+
 		log.Printf("ignoring push message")
 
 	case RequestMessageType:
 
-		log.Printf("processing request message")
-
 		topic := websocketMessage.Topic
 		method := websocketMessage.Method
-		handlerName := fmt.Sprintf("%d.%s", topic, method)
 
-		handler, ok := client.handlers[handlerName]
+		handler, ok := websocketRpcRegistry.lookup(topic, method)
 		if !ok {
-			return errors.New(fmt.Sprintf("handler not found for the websocket message method: %s", method))
+			err := fmt.Errorf("handler not found for the websocket message method: %s", method)
+			return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeUnknownMethod, err, map[string]interface{}{"topic": topic, "method": method})
 		}
 
 		args := websocketMessage.Args
@@ -48,12 +51,25 @@ func (client *WebsocketClient) onMessageReceived(websocketMessage *WebsocketMess
 
 	case ResponseMessageType:
 		log.Printf("processing response message")
-		delete(client.requests, websocketMessage.Id)
+		if !client.completePendingRequest(websocketMessage.Id, websocketRequestResult{response: websocketMessage}) {
+			log.Printf("ignoring response to unknown or expired request: %s", websocketMessage.Id)
+		}
 	}
 
 	return nil
 }
 
+// Responds to the request which can not be decoded with the error. The other messages are ignored.
+func (client *WebsocketClient) onMessageDecodeFailed(websocketMessage *WebsocketMessage, index int, err error) error {
+	if websocketMessage.Type == PushMessageType || websocketMessage.Type == ResponseMessageType {
+		log.Errorf("ignoring invalid message, client: {%s}, message: {%s}, type: {%d}: %s", client.Id, websocketMessage.Id, websocketMessage.Type, err.Error())
+		return nil
+	}
+
+	return client.sendErrorResponseWithDetails(websocketMessage, ErrorCodeInvalidMessage, err, map[string]interface{}{"index": index})
+}
+
+// Sends the request to the client without waiting for the response. Use Call to await the response.
 func (client *WebsocketClient) sendRequestMessage(topic WebsocketTopic, method string, args interface{}) (err error) {
 
 	request := WebsocketMessage{
@@ -65,9 +81,14 @@ func (client *WebsocketClient) sendRequestMessage(topic WebsocketTopic, method s
 	}
 
 	// Save request for timeout detection.
-	client.requests[request.Id] = time.Now().Add(pongWait)
+	client.addPendingRequest(&request, time.Now().Add(pongWait))
+
+	return client.writeRequestMessage(&request)
+}
+
+func (client *WebsocketClient) writeRequestMessage(request *WebsocketMessage) (err error) {
 
-	encodedMessage, err := client.server.serializer.serialize(&request)
+	encodedMessage, err := client.serializer.Serialize(request)
 
 	if err != nil {
 		log.Errorf("error serializing an rpc request message: %s", err.Error())
@@ -76,9 +97,7 @@ func (client *WebsocketClient) sendRequestMessage(topic WebsocketTopic, method s
 
 	log.Printf("sendRequestMessage %s, %s", request.Method, request.Id.String())
 
-	client.send <- encodedMessage
-
-	return
+	return client.enqueue(encodedMessage, outboundPrioritySystem)
 }
 
 func (client *WebsocketClient) sendResponseMessage(websocketMessage *WebsocketMessage, payload interface{}) (err error) {
@@ -90,7 +109,7 @@ func (client *WebsocketClient) sendResponseMessage(websocketMessage *WebsocketMe
 		Payload: payload,
 	}
 
-	encodedMessage, err := client.server.serializer.serialize(&response)
+	encodedMessage, err := client.serializer.Serialize(&response)
 
 	if err != nil {
 		log.Errorf("error serializing an rpc response message: %s", err.Error())
@@ -99,26 +118,56 @@ func (client *WebsocketClient) sendResponseMessage(websocketMessage *WebsocketMe
 
 	log.Printf("sendResponseMessage %s, %s", response.Method, response.Id.String())
 
-	client.send <- encodedMessage
-
-	return
+	return client.enqueue(encodedMessage, outboundPrioritySystem)
 }
 
-// Sends the text message to the frontend via websocket.
-func (client *WebsocketClient) SendPushMessage(topic WebsocketTopic, payload interface{}) (err error) {
-
-	message := WebsocketMessage{
+func newPushMessage(topic WebsocketTopic, payload interface{}) WebsocketMessage {
+	return WebsocketMessage{
 		Id:      uuid.New(),
 		Type:    PushMessageType,
 		Topic:   topic,
 		Payload: payload,
 	}
+}
+
+// Sends the text message to the frontend via websocket. Messages sent within the session are sequenced and kept for replay.
+func (client *WebsocketClient) SendPushMessage(topic WebsocketTopic, payload interface{}) (err error) {
 
-	serializedMessage, err := client.server.serializer.serialize(&message)
+	message := newPushMessage(topic, payload)
+
+	if session := client.getSession(); session != nil {
+		return session.push(message)
+	}
+
+	return client.writePushMessage(&message)
+}
 
-	log.Printf("SendPushMessage %s, %s", message.Topic, message.Id.String())
+func (client *WebsocketClient) writePushMessage(message *WebsocketMessage) (err error) {
 
-	client.send <- serializedMessage
+	serializedMessage, err := client.serializer.Serialize(message)
 
-	return
+	if err != nil {
+		log.Errorf("error serializing a push message: %s", err.Error())
+		return err
+	}
+
+	log.Printf("SendPushMessage %d, %s, seq: %d", message.Topic, message.Id.String(), message.Seq)
+
+	return client.enqueue(serializedMessage, getPushMessagePriority(message.Topic))
+}
+
+// Push messages are written by the topic priority: system, vivox, chat, then analytics. Messages sequenced by the
+// session may be reordered and dropped as well, the session keeps them until acknowledged for the retransmission and
+// the replay on resume.
+func getPushMessagePriority(topic WebsocketTopic) outboundPriority {
+	switch topic {
+	case SystemTopic:
+		return outboundPrioritySystem
+	case VivoxTopic:
+		return outboundPriorityVivox
+	case ChatTopic:
+		return outboundPriorityChat
+	default:
+		return outboundPriorityAnalytics
+	}
 }
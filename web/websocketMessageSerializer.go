@@ -1,18 +1,123 @@
 package web
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// Websocket subprotocols used to negotiate the message serializer.
+const (
+	JsonSubprotocol    string = "artheon.json"
+	MsgpackSubprotocol string = "artheon.msgpack"
 )
 
-type WebsocketMessageSerializer struct {
+// Encodes and decodes websocket messages. A serializer is negotiated for each connection via the websocket subprotocol.
+type WebsocketMessageSerializer interface {
+	// Websocket subprotocol the serializer is negotiated with.
+	Subprotocol() string
+	// Websocket frame type used to send serialized messages, websocket.TextMessage or websocket.BinaryMessage.
+	FrameType() int
+	Serialize(websocketMessage *WebsocketMessage) ([]byte, error)
+	// Decodes the messages of the frame: a single message or a batch of the messages to handle in order. A message
+	// which can not be decoded does not fail the rest of the batch.
+	Deserialize(message []byte) []WebsocketDecodedMessage
+}
+
+// Message of the frame. Err is set if the message can not be decoded, the message then has only the id, type, topic
+// and method which could be read, so the error response can be matched with the request.
+type WebsocketDecodedMessage struct {
+	Message *WebsocketMessage
+	Err     error
+}
+
+// Serializers supported by the server, in the order of preference.
+var websocketMessageSerializers = []WebsocketMessageSerializer{
+	newMsgpackMessageSerializer(),
+	newJsonMessageSerializer(),
+}
+
+// Serializer used when the client does not request a subprotocol.
+var defaultWebsocketMessageSerializer = websocketMessageSerializers[1]
+
+// Lists subprotocols of all the supported serializers.
+func getWebsocketSubprotocols() []string {
+	subprotocols := make([]string, 0, len(websocketMessageSerializers))
+	for _, serializer := range websocketMessageSerializers {
+		subprotocols = append(subprotocols, serializer.Subprotocol())
+	}
+	return subprotocols
+}
+
+// Finds the serializer for the subprotocol negotiated with the client. Falls back to JSON.
+func getWebsocketMessageSerializer(subprotocol string) WebsocketMessageSerializer {
+	for _, serializer := range websocketMessageSerializers {
+		if serializer.Subprotocol() == subprotocol {
+			return serializer
+		}
+	}
+	return defaultWebsocketMessageSerializer
+}
+
+// Reads the fields identifying the message which can not be decoded. The invalid fields are left zero.
+func newWebsocketMessageHeader(fields map[string]interface{}) *WebsocketMessage {
+	header := &WebsocketMessage{}
+
+	if id, ok := fields["id"].(string); ok {
+		header.Id, _ = uuid.Parse(id)
+	}
+	if messageType, ok := getHeaderNumber(fields["type"]); ok {
+		header.Type = WebsocketMessageType(messageType)
+	}
+	if topic, ok := getHeaderNumber(fields["topic"]); ok {
+		header.Topic = WebsocketTopic(topic)
+	}
+	if method, ok := fields["method"].(string); ok {
+		header.Method = method
+	}
+
+	return header
+}
+
+// Gets the number decoded by any of the serializers.
+func getHeaderNumber(value interface{}) (int32, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int32(v), true
+	case int64:
+		return int32(v), true
+	case uint64:
+		return int32(v), true
+	}
+	return 0, false
+}
+
+//region json
+
+type jsonMessageSerializer struct {
+}
+
+func newJsonMessageSerializer() *jsonMessageSerializer {
+	return &jsonMessageSerializer{}
+}
+
+func (serializer *jsonMessageSerializer) Subprotocol() string {
+	return JsonSubprotocol
 }
 
-func newWebsocketMessageSerializer() *WebsocketMessageSerializer {
-	return &WebsocketMessageSerializer{}
+func (serializer *jsonMessageSerializer) FrameType() int {
+	return websocket.TextMessage
 }
 
-func (serializer *WebsocketMessageSerializer) serialize(websocketMessage *WebsocketMessage) ([]byte, error) {
+func (serializer *jsonMessageSerializer) Serialize(websocketMessage *WebsocketMessage) ([]byte, error) {
 
 	encodedString, err := json.Marshal(websocketMessage)
 
@@ -24,13 +129,181 @@ func (serializer *WebsocketMessageSerializer) serialize(websocketMessage *Websoc
 	return encodedString, nil
 }
 
-func (serializer *WebsocketMessageSerializer) deserialize(message []byte) (websocketMessage *WebsocketMessage, err error) {
-	err = json.Unmarshal(message, &websocketMessage)
+// Decodes a single message, the batch of the newline delimited messages or the JSON array of the messages.
+// An invalid element of the array or an invalid line of the batch is skipped.
+func (serializer *jsonMessageSerializer) Deserialize(message []byte) (websocketMessages []WebsocketDecodedMessage) {
+	message = bytes.TrimSpace(message)
+
+	if len(message) > 0 && message[0] == '[' {
+		var elements []json.RawMessage
+
+		if err := json.Unmarshal(message, &elements); err != nil {
+			log.Errorf("got an error unmarshalling a json encoded batch: %s", err)
+			return []WebsocketDecodedMessage{{Message: &WebsocketMessage{}, Err: err}}
+		}
+
+		for _, element := range elements {
+			websocketMessages = append(websocketMessages, decodeJsonMessage(element))
+		}
 
-	if err != nil {
-		log.Errorf("got an error unmarshalling a json encoded string: %s", err)
 		return
 	}
 
+	for len(message) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(message))
+
+		var element json.RawMessage
+
+		if err := decoder.Decode(&element); err != nil {
+			log.Errorf("got an error unmarshalling a json encoded string: %s", err)
+
+			// Skip the invalid line, the messages of the batch are newline delimited.
+			line, rest, _ := bytes.Cut(message, newline)
+			websocketMessages = append(websocketMessages, WebsocketDecodedMessage{Message: decodeJsonMessageHeader(line), Err: err})
+			message = bytes.TrimSpace(rest)
+			continue
+		}
+
+		websocketMessages = append(websocketMessages, decodeJsonMessage(element))
+		message = bytes.TrimSpace(message[decoder.InputOffset():])
+	}
+
+	return
+}
+
+func decodeJsonMessage(element []byte) WebsocketDecodedMessage {
+	var websocketMessage *WebsocketMessage
+
+	if err := json.Unmarshal(element, &websocketMessage); err != nil {
+		log.Errorf("got an error unmarshalling a json encoded message: %s", err)
+		return WebsocketDecodedMessage{Message: decodeJsonMessageHeader(element), Err: err}
+	}
+
+	return WebsocketDecodedMessage{Message: websocketMessage}
+}
+
+func decodeJsonMessageHeader(element []byte) *WebsocketMessage {
+	var fields map[string]interface{}
+	_ = json.Unmarshal(element, &fields)
+	return newWebsocketMessageHeader(fields)
+}
+
+//endregion json
+
+//region msgpack
+
+var registerMsgpackTypesOnce sync.Once
+
+// Encodes UUIDs as strings so both serializers produce the same values for the client.
+func registerMsgpackTypes() {
+	msgpack.Register(uuid.UUID{},
+		func(e *msgpack.Encoder, v reflect.Value) error {
+			return e.EncodeString(v.Interface().(uuid.UUID).String())
+		},
+		func(d *msgpack.Decoder, v reflect.Value) error {
+			s, err := d.DecodeString()
+			if err != nil {
+				return err
+			}
+			if s == "" {
+				v.Set(reflect.ValueOf(uuid.Nil))
+				return nil
+			}
+			id, err := uuid.Parse(s)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(id))
+			return nil
+		})
+}
+
+type msgpackMessageSerializer struct {
+}
+
+func newMsgpackMessageSerializer() *msgpackMessageSerializer {
+	registerMsgpackTypesOnce.Do(registerMsgpackTypes)
+	return &msgpackMessageSerializer{}
+}
+
+func (serializer *msgpackMessageSerializer) Subprotocol() string {
+	return MsgpackSubprotocol
+}
+
+func (serializer *msgpackMessageSerializer) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (serializer *msgpackMessageSerializer) Serialize(websocketMessage *WebsocketMessage) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	encoder := msgpack.NewEncoder(&buffer)
+	// Reuse json tags to keep field names identical across serializers.
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+
+	if err := encoder.Encode(websocketMessage); err != nil {
+		log.Errorf("got an error serializing the websocket message: %s", err)
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Decodes a single message, the concatenated messages or the msgpack array of the messages. An invalid message is
+// skipped unless the rest of the frame can not be read past it.
+func (serializer *msgpackMessageSerializer) Deserialize(message []byte) (websocketMessages []WebsocketDecodedMessage) {
+	decoder := newMsgpackDecoder(message)
+
+	// Number of the messages in the array, or -1 for the concatenated messages.
+	count := -1
+
+	if code, err := decoder.PeekCode(); err == nil && (msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32) {
+		if count, err = decoder.DecodeArrayLen(); err != nil {
+			log.Errorf("got an error unmarshalling a msgpack encoded batch: %s", err)
+			return []WebsocketDecodedMessage{{Message: &WebsocketMessage{}, Err: err}}
+		}
+	}
+
+	for i := 0; count < 0 || i < count; i++ {
+		element, err := decoder.DecodeRaw()
+
+		if count < 0 && err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			log.Errorf("got an error unmarshalling a msgpack encoded message: %s", err)
+			return append(websocketMessages, WebsocketDecodedMessage{Message: &WebsocketMessage{}, Err: err})
+		}
+
+		websocketMessages = append(websocketMessages, decodeMsgpackMessage(element))
+	}
+
 	return
 }
+
+func newMsgpackDecoder(message []byte) *msgpack.Decoder {
+	decoder := msgpack.NewDecoder(bytes.NewReader(message))
+	decoder.SetCustomStructTag("json")
+	// Decode numbers as int64, uint64 and float64 like encoding/json does for the loosely typed args.
+	decoder.UseLooseInterfaceDecoding(true)
+	return decoder
+}
+
+func decodeMsgpackMessage(element []byte) WebsocketDecodedMessage {
+	var websocketMessage *WebsocketMessage
+
+	if err := newMsgpackDecoder(element).Decode(&websocketMessage); err != nil {
+		log.Errorf("got an error unmarshalling a msgpack encoded message: %s", err)
+
+		var fields map[string]interface{}
+		_ = newMsgpackDecoder(element).Decode(&fields)
+
+		return WebsocketDecodedMessage{Message: newWebsocketMessageHeader(fields), Err: err}
+	}
+
+	return WebsocketDecodedMessage{Message: websocketMessage}
+}
+
+//endregion msgpack
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"reflect"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+)
+
+//region method args
+
+type ConnectArgs struct {
+	Key          string `json:"key" validate:"required,max=8192"`          // Session token issued by the API.
+	SessionToken string `json:"sessionToken,omitempty" validate:"max=256"` // Token of the session to resume, returned by the previous connect.
+	LastSeq      uint64 `json:"lastSeq,omitempty"`                         // Sequence number of the last push message received within the resumed session.
+}
+
+type PingArgs struct {
+	ClientTime int64 `json:"clientTime,omitempty"` // Client time sending the ping, unix milliseconds, echoed in the response.
+}
+
+type UserChangeNameArgs struct {
+}
+
+type PresenceArgs struct {
+	Status   string    `json:"status" validate:"required,oneof=playing|available|away|offline"`
+	SpaceId  uuid.UUID `json:"spaceId,omitempty"`
+	ServerId uuid.UUID `json:"serverId,omitempty"`
+}
+
+type PresenceUpdateArgs struct {
+	Presence PresenceArgs `json:"presence" validate:"required"`
+}
+
+type ChannelSendArgs struct {
+	ChannelId uuid.UUID `json:"channelId" validate:"required"`
+	Message   string    `json:"message" validate:"required,max=2048,maxBytes=6144"`
+}
+
+type ChannelHistoryArgs struct {
+	ChannelId  uuid.UUID `json:"channelId" validate:"required"`
+	Before     uuid.UUID `json:"before,omitempty"`                      // Messages sent before the message with the id.
+	After      uuid.UUID `json:"after,omitempty"`                       // Messages sent after the message with the id.
+	BeforeTime int64     `json:"beforeTime,omitempty" validate:"min=0"` // Messages sent before the time, unix milliseconds.
+	AfterTime  int64     `json:"afterTime,omitempty" validate:"min=0"`  // Messages sent after the time, unix milliseconds.
+	Limit      int       `json:"limit,omitempty" validate:"min=0,max=100"`
+}
+
+type MessageEditArgs struct {
+	MessageId uuid.UUID `json:"messageId" validate:"required"`
+	Message   string    `json:"message" validate:"required,max=2048,maxBytes=6144"`
+}
+
+type MessageDeleteArgs struct {
+	MessageId uuid.UUID `json:"messageId" validate:"required"`
+}
+
+type GroupCreateArgs struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+type GroupInviteArgs struct {
+	ChannelId uuid.UUID `json:"channelId" validate:"required"`
+	UserId    uuid.UUID `json:"userId" validate:"required"`
+}
+
+type GroupKickArgs struct {
+	ChannelId uuid.UUID `json:"channelId" validate:"required"`
+	UserId    uuid.UUID `json:"userId" validate:"required"`
+}
+
+type GroupLeaveArgs struct {
+	ChannelId uuid.UUID `json:"channelId" validate:"required"`
+}
+
+type GroupListArgs struct {
+}
+
+type ChannelSubscribeArgs struct {
+	ChannelId uuid.UUID `json:"channelId,omitempty"`                // Channel to subscribe to, required unless the user id is set.
+	UserId    uuid.UUID `json:"userId,omitempty"`                   // User to open the private channel with, instead of the channel id.
+	History   int       `json:"history,omitempty" validate:"min=0"` // Number of the latest channel messages to return, capped by the server.
+}
+
+type ChannelUnsubscribeArgs struct {
+	ChannelId uuid.UUID `json:"channelId" validate:"required"`
+}
+
+type UserActionArgs struct {
+	Message models.Action `json:"message" validate:"required"`
+}
+
+type VivoxArgs struct {
+	VivoxPayload models.VivoxTokenPayload `json:"vivoxPayload" validate:"required"`
+}
+
+//endregion method args
+
+// Request handler receiving args decoded and validated according to the args struct.
+type websocketTypedRequestHandler[T any] func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args *T) error
+
+// Describes the RPC method implemented by the server.
+type websocketMethod struct {
+	Topic       WebsocketTopic
+	Method      string
+	Description string
+	Args        reflect.Type
+	Handler     websocketRequestHandler
+	// Middleware applied to this method only, inside of the registry middleware.
+	Middleware []websocketMiddleware
+}
+
+// Declares the method with typed args. Args are decoded and validated before the handler is called.
+func newWebsocketMethod[T any](topic WebsocketTopic, method string, description string, handler websocketTypedRequestHandler[T]) websocketMethod {
+	return websocketMethod{
+		Topic:       topic,
+		Method:      method,
+		Description: description,
+		Args:        reflect.TypeOf((*T)(nil)).Elem(),
+		Handler: func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) error {
+			var typedArgs T
+
+			if err := decodeArgs(args, &typedArgs); err != nil {
+				var details map[string]interface{}
+				if argsErr, ok := err.(*WebsocketArgsError); ok {
+					details = argsErr.details()
+				}
+				return client.sendErrorResponseWithDetails(message, ErrorCodeInvalidArgs, err, details)
+			}
+
+			return handler(client, message, topic, method, &typedArgs)
+		},
+	}
+}
+
+// Adds the method middleware.
+func (m websocketMethod) with(middleware ...websocketMiddleware) websocketMethod {
+	m.Middleware = append(append([]websocketMiddleware{}, m.Middleware...), middleware...)
+	return m
+}
+
+// Requires the client to complete the connect handshake before calling the method.
+func (m websocketMethod) authenticated() websocketMethod {
+	return m.with(rpcAuthenticationMiddleware)
+}
+
+// Registry of the RPC methods implemented by the server.
+var websocketRpcRegistry = newDefaultWebsocketRegistry()
+
+func newDefaultWebsocketRegistry() *websocketRegistry {
+	registry := newWebsocketRegistry(
+		rpcRecoveryMiddleware,
+		rpcLoggingMiddleware,
+		rpcRateLimitMiddleware,
+		rpcTimingMiddleware,
+	)
+
+	registry.register(
+		newWebsocketMethod(SystemTopic, ConnectMethod, "Authenticates the connection with the session token. Resumes the previous session if its token is passed.", connectHandler),
+		newWebsocketMethod(SystemTopic, PingMethod, "Returns the server time and the round trip time, allowing the client to estimate the clock offset.", pingHandler),
+		newWebsocketMethod(SystemTopic, PresenceUpdateMethod, "Updates presence of the authenticated user.", presenceUpdateHandler).authenticated(),
+		newWebsocketMethod(SystemTopic, UserChangeNameMethod, "Reloads the authenticated user after the name change.", userChangeNameHandler).authenticated(),
+
+		newWebsocketMethod(ChatTopic, ChannelSendMethod, "Sends the message to the subscribed channel.", channelMessageHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, ChannelHistoryMethod, "Returns the page of the messages sent to the subscribed channel, the latest ones by default.", channelHistoryHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, MessageEditMethod, "Edits the message. Allowed to the author and the moderators.", messageEditHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, MessageDeleteMethod, "Deletes the message. Allowed to the author and the moderators.", messageDeleteHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, GroupCreateMethod, "Creates the group channel owned by the user and subscribes to it.", groupCreateHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, GroupInviteMethod, "Adds the user to the group channel. Allowed to the owner.", groupInviteHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, GroupKickMethod, "Removes the user from the group channel. Allowed to the owner.", groupKickHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, GroupLeaveMethod, "Leaves the group channel. The ownership passes to the earliest joined member.", groupLeaveHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, GroupListMethod, "Returns the group channels of the user.", groupListHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, ChannelSubscribeMethod, "Subscribes to the channel. Passing the user id instead of the channel id opens the private channel with the user. Private and group channels require the membership.", channelSubscribeHandler).authenticated(),
+		newWebsocketMethod(ChatTopic, ChannelUnsubscribeMethod, "Unsubscribes from the channel.", channelUnsubscribeHandler).authenticated(),
+
+		newWebsocketMethod(AnalyticsTopic, UserActionMethod, "Reports the user action.", userActionHandler).authenticated(),
+
+		newWebsocketMethod(VivoxTopic, VivoxGetLoginTokenMethod, "Issues the Vivox login token.", vivoxHandler).authenticated(),
+		newWebsocketMethod(VivoxTopic, VivoxGetJoinTokenMethod, "Issues the Vivox channel join token.", vivoxHandler).authenticated(),
+		newWebsocketMethod(VivoxTopic, VivoxMuteMethod, "Mutes the user in the Vivox channel. Requires the moderator role.", vivoxHandler).authenticated(),
+		newWebsocketMethod(VivoxTopic, VivoxUnmuteMethod, "Unmutes the user in the Vivox channel. Requires the moderator role.", vivoxHandler).authenticated(),
+		newWebsocketMethod(VivoxTopic, VivoxKickMethod, "Kicks the user from the Vivox channel. Requires the moderator role.", vivoxHandler).authenticated(),
+	)
+
+	return registry
+}
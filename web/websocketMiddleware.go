@@ -0,0 +1,66 @@
+package web
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Calls slower than this are logged as warnings.
+const slowRequestThreshold = 500 * time.Millisecond
+
+// Recovers from handler panics and responds with the internal error so the client connection survives.
+func rpcRecoveryMiddleware(next websocketRequestHandler) websocketRequestHandler {
+	return func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("rpc handler panic, method: {%s}: %v\n%s", getMethodName(topic, method), r, debug.Stack())
+				err = client.sendErrorResponse(message, ErrorCodeInternal, fmt.Errorf("handler panic: %v", r))
+			}
+		}()
+
+		return next(client, message, topic, method, args)
+	}
+}
+
+// Logs every method call.
+func rpcLoggingMiddleware(next websocketRequestHandler) websocketRequestHandler {
+	return func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) error {
+		log.Printf("processing request message, client: {%s}, message: {%s}, method: {%s}", client.Id, message.Id, getMethodName(topic, method))
+		return next(client, message, topic, method, args)
+	}
+}
+
+// Measures method call durations and reports slow calls.
+func rpcTimingMiddleware(next websocketRequestHandler) websocketRequestHandler {
+	return func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) error {
+		startedAt := time.Now()
+
+		err := next(client, message, topic, method, args)
+
+		elapsed := time.Since(startedAt)
+		if elapsed > slowRequestThreshold {
+			log.Warnf("slow request, client: {%s}, message: {%s}, method: {%s}, elapsed: {%s}", client.Id, message.Id, getMethodName(topic, method), elapsed)
+		} else {
+			log.Debugf("processed request, client: {%s}, message: {%s}, method: {%s}, elapsed: {%s}", client.Id, message.Id, getMethodName(topic, method), elapsed)
+		}
+
+		return err
+	}
+}
+
+// Rejects calls from the clients which have not completed the connect handshake.
+func rpcAuthenticationMiddleware(next websocketRequestHandler) websocketRequestHandler {
+	return func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) error {
+		if client.user == nil || client.identity == nil {
+			return client.sendErrorResponse(message, ErrorCodeNotAuthenticated, errClientNotAuthenticated)
+		}
+		// The connection is closed when the token expires, the requests received meanwhile are rejected.
+		if client.identity.IsExpired(time.Now()) {
+			return client.sendErrorResponse(message, ErrorCodeNotAuthenticated, errAuthTokenExpired)
+		}
+		return next(client, message, topic, method, args)
+	}
+}
@@ -0,0 +1,197 @@
+package web
+
+import (
+	"errors"
+	"sync"
+
+	config "github.com/spf13/viper"
+)
+
+// Slow consumer policies applied when the outbound queue of the client is full.
+const (
+	SlowConsumerDropOldest string = "dropOldest" // Drop the oldest chat or analytics message, disconnect if there is none.
+	SlowConsumerDisconnect string = "disconnect" // Disconnect the client.
+)
+
+const defaultOutboundQueueSize = 256
+
+var errClientTooSlow = errors.New("client outbound queue overflow")
+
+// Outbound messages are written in the order of the priority, so the system messages never wait behind the chat.
+type outboundPriority int
+
+const (
+	outboundPriorityAnalytics outboundPriority = iota // Analytics push messages, may be dropped.
+	outboundPriorityChat                              // Chat push messages, may be dropped.
+	outboundPriorityVivox                             // Vivox push messages, never dropped.
+	outboundPrioritySystem                            // Responses, requests and system push messages, never dropped.
+	outboundPriorityCount
+)
+
+// Maximal number of the messages written at once, the queue is checked for the higher priority messages in between.
+const outboundWriteBatchSize = 32
+
+// Messages of the priority may be dropped by the slow consumer policy.
+func (priority outboundPriority) isDroppable() bool {
+	return priority <= outboundPriorityChat
+}
+
+type OutboundConfig struct {
+	// Maximal number of the messages queued for the client.
+	QueueSize int
+	// Policy applied when the queue is full.
+	Policy string
+}
+
+// Reads the "rpc.outbound" configuration section.
+func newOutboundConfigFromConfig() OutboundConfig {
+	return OutboundConfig{
+		QueueSize: config.GetInt("rpc.outbound.queueSize"),
+		Policy:    config.GetString("rpc.outbound.policy"),
+	}
+}
+
+// Outbound queue counters reported for the client.
+type OutboundQueueStats struct {
+	Length        int    `json:"length"`        // Number of the queued messages.
+	Dropped       uint64 `json:"dropped"`       // Number of the messages dropped by the slow consumer policy.
+	HighWaterMark int    `json:"highWaterMark"` // Maximal number of the queued messages.
+}
+
+// Bounded queue of the messages to write to the websocket connection. Never blocks the producers.
+type outboundQueue struct {
+	lock sync.Mutex
+	// Queued messages by the priority, in the order of queueing.
+	lanes    [outboundPriorityCount][][]byte
+	length   int
+	capacity int
+	closed   bool
+	// Signaled when messages are queued or the queue is closed.
+	notify chan struct{}
+	stats  OutboundQueueStats
+}
+
+func newOutboundQueue(capacity int) *outboundQueue {
+	if capacity <= 0 {
+		capacity = defaultOutboundQueueSize
+	}
+
+	return &outboundQueue{
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (queue *outboundQueue) signal() {
+	select {
+	case queue.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Queues the message. If the queue is full, drops the oldest message of the lowest droppable priority if allowed.
+// Returns false if the message can not be queued.
+func (queue *outboundQueue) push(data []byte, priority outboundPriority, dropOldest bool) bool {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if queue.closed {
+		return false
+	}
+
+	if queue.length >= queue.capacity {
+		if !dropOldest {
+			return false
+		}
+
+		lane := outboundPriority(0)
+		for lane.isDroppable() && len(queue.lanes[lane]) == 0 {
+			lane++
+		}
+
+		// The message itself is the one to drop if there are no queued messages of the same or lower priority.
+		if priority.isDroppable() && priority < lane {
+			queue.stats.Dropped++
+			return true
+		}
+
+		if !lane.isDroppable() {
+			return false
+		}
+
+		queue.lanes[lane] = queue.lanes[lane][1:]
+		queue.length--
+		queue.stats.Dropped++
+	}
+
+	queue.lanes[priority] = append(queue.lanes[priority], data)
+	queue.length++
+	if queue.length > queue.stats.HighWaterMark {
+		queue.stats.HighWaterMark = queue.length
+	}
+
+	queue.signal()
+
+	return true
+}
+
+// Takes up to the count of the queued messages of the highest priority. Returns false if the queue is closed.
+func (queue *outboundQueue) pop(count int) ([][]byte, bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if queue.closed {
+		return nil, false
+	}
+
+	for priority := outboundPrioritySystem; priority >= 0; priority-- {
+		lane := queue.lanes[priority]
+		if len(lane) == 0 {
+			continue
+		}
+
+		if count > len(lane) {
+			count = len(lane)
+		}
+
+		messages := append([][]byte{}, lane[:count]...)
+		queue.lanes[priority] = lane[count:]
+		queue.length -= count
+
+		return messages, true
+	}
+
+	return nil, true
+}
+
+// Closes the queue dropping the queued messages. Returns false if the queue has already been closed.
+func (queue *outboundQueue) close() bool {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if queue.closed {
+		return false
+	}
+
+	queue.closed = true
+	queue.lanes = [outboundPriorityCount][][]byte{}
+	queue.length = 0
+	queue.signal()
+
+	return true
+}
+
+func (queue *outboundQueue) isClosed() bool {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return queue.closed
+}
+
+func (queue *outboundQueue) getStats() OutboundQueueStats {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	stats := queue.stats
+	stats.Length = queue.length
+	return stats
+}
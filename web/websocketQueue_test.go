@@ -0,0 +1,200 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+)
+
+// Pushes the messages from many goroutines while the write goroutine drains the queue and the queue gets closed.
+// Run with -race.
+func TestOutboundQueueConcurrentPushAndClose(t *testing.T) {
+	const (
+		producers  = 100
+		iterations = 100
+	)
+
+	queue := newOutboundQueue(16)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			priority := outboundPriority(i % int(outboundPriorityCount))
+			for n := 0; n < iterations; n++ {
+				queue.push([]byte("message"), priority, i%3 != 0)
+			}
+		}(i)
+	}
+
+	// Write goroutine.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range queue.notify {
+			if _, ok := queue.pop(outboundWriteBatchSize); !ok {
+				return
+			}
+		}
+	}()
+
+	// The client disconnects while the messages are pushed.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+		for n := 0; n < iterations/2; n++ {
+			_ = queue.getStats()
+		}
+		queue.close()
+	}()
+
+	close(start)
+	wg.Wait()
+	<-done
+
+	if queue.push([]byte("message"), outboundPrioritySystem, true) {
+		t.Errorf("message has been queued after the queue has been closed")
+	}
+	if queue.close() {
+		t.Errorf("queue has been closed twice")
+	}
+	if stats := queue.getStats(); stats.Length != 0 {
+		t.Errorf("closed queue keeps {%d} messages", stats.Length)
+	}
+}
+
+// Floods the session of the client with the chat messages it does not read. The system messages still go out and the
+// client stays connected, while the dropped chat messages are kept by the session for the retransmission.
+func TestWebsocketClientChatFloodKeepsSystemMessages(t *testing.T) {
+	defer quietTestLog()()
+
+	server := newTestWebsocketServer()
+	server.sessions = newWebsocketSessionStore(WebsocketSessionSettings{BufferSize: 1024})
+
+	client := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+	client.conn = newTestWebsocketConn(t)
+
+	if _, err := client.startSession(); err != nil {
+		t.Fatalf("failed to start the session: %s", err.Error())
+	}
+
+	chatCount := 10 * server.outbound.QueueSize
+	for n := 0; n < chatCount; n++ {
+		if err := client.SendPushMessage(ChatTopic, WebsocketPayload{Message: "chat"}); err != nil {
+			t.Fatalf("failed to push the chat message: %s", err.Error())
+		}
+	}
+
+	if err := client.SendPushMessage(SystemTopic, WebsocketPayload{Message: "system"}); err != nil {
+		t.Fatalf("failed to push the system message: %s", err.Error())
+	}
+
+	if client.send.isClosed() {
+		t.Fatalf("client has been disconnected")
+	}
+
+	if stats := client.send.getStats(); stats.Dropped != uint64(chatCount+1-server.outbound.QueueSize) {
+		t.Errorf("dropped {%d} messages, expected {%d}", stats.Dropped, chatCount+1-server.outbound.QueueSize)
+	}
+
+	messages, ok := client.send.pop(1)
+	if !ok || len(messages) != 1 || !bytes.Contains(messages[0], []byte("system")) {
+		t.Errorf("system message has not been written first")
+	}
+
+	session := client.getSession()
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if len(session.buffer) != chatCount+1 {
+		t.Errorf("session keeps {%d} messages, expected {%d}", len(session.buffer), chatCount+1)
+	}
+}
+
+// Floods the session of the client with the chat messages it does not read under each slow consumer policy. The
+// sequenced messages are dropped or the client is disconnected, while the session keeps all of them for the replay.
+func TestWebsocketClientSlowConsumerPolicies(t *testing.T) {
+	defer quietTestLog()()
+
+	tests := []struct {
+		policy       string
+		disconnected bool
+	}{
+		{policy: SlowConsumerDropOldest, disconnected: false},
+		{policy: SlowConsumerDisconnect, disconnected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			server := newTestWebsocketServer()
+			server.outbound.Policy = test.policy
+			server.sessions = newWebsocketSessionStore(WebsocketSessionSettings{BufferSize: 1024})
+
+			client := newTestWebsocketClient(t, server, &models.User{Id: uuid.New()})
+			client.conn = newTestWebsocketConn(t)
+
+			if _, err := client.startSession(); err != nil {
+				t.Fatalf("failed to start the session: %s", err.Error())
+			}
+
+			queueSize := server.outbound.QueueSize
+			chatCount := 2 * queueSize
+
+			failed := 0
+			for n := 0; n < chatCount; n++ {
+				if err := client.SendPushMessage(ChatTopic, WebsocketPayload{Message: "chat"}); err != nil {
+					if !errors.Is(err, errClientTooSlow) {
+						t.Fatalf("failed to push the chat message: %s", err.Error())
+					}
+					failed++
+				}
+			}
+
+			if client.send.isClosed() != test.disconnected {
+				t.Errorf("client disconnected: {%t}, expected {%t}", client.send.isClosed(), test.disconnected)
+			}
+
+			if test.disconnected {
+				if failed != chatCount-queueSize {
+					t.Errorf("failed to push {%d} messages, expected {%d}", failed, chatCount-queueSize)
+				}
+			} else {
+				if failed != 0 {
+					t.Errorf("failed to push {%d} messages", failed)
+				}
+
+				// The latest messages are queued in order.
+				messages, _ := client.send.pop(chatCount)
+				if len(messages) != queueSize {
+					t.Fatalf("queued {%d} messages, expected {%d}", len(messages), queueSize)
+				}
+				for i, data := range messages {
+					decoded := client.serializer.Deserialize(data)
+					if len(decoded) != 1 || decoded[0].Err != nil {
+						t.Fatalf("failed to decode the queued message")
+					}
+					if seq := decoded[0].Message.Seq; seq != uint64(chatCount-queueSize+i+1) {
+						t.Errorf("queued message {%d} has seq {%d}, expected {%d}", i, seq, chatCount-queueSize+i+1)
+					}
+				}
+			}
+
+			session := client.getSession()
+			session.lock.Lock()
+			defer session.lock.Unlock()
+
+			if session.lastSeq != uint64(chatCount) || len(session.buffer) != chatCount || !session.canReplayFrom(0) {
+				t.Errorf("session keeps {%d} of {%d} messages", len(session.buffer), session.lastSeq)
+			}
+		})
+	}
+}
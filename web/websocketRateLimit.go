@@ -0,0 +1,253 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	config "github.com/spf13/viper"
+)
+
+// Rate limit scopes reported to the clients.
+const (
+	rateLimitScopeConnection = "connection"
+	rateLimitScopeUser       = "user"
+	rateLimitScopeMethod     = "method"
+)
+
+// Idle user buckets are dropped after this period.
+const userRateLimitIdleTimeout = 10 * time.Minute
+
+// Token bucket refilled at the constant rate up to the burst size.
+type tokenBucket struct {
+	lock      sync.Mutex
+	rate      float64
+	burst     float64
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:      limit.Rate,
+		burst:     float64(limit.Burst),
+		tokens:    float64(limit.Burst),
+		updatedAt: now,
+	}
+}
+
+// Takes a token if available. Otherwise, returns the time until the next token.
+func (bucket *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*bucket.rate)
+	bucket.updatedAt = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+func (bucket *tokenBucket) idleSince() time.Time {
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+	return bucket.updatedAt
+}
+
+// Rate of requests per second allowing bursts. Disabled if the rate is not positive.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (limit RateLimit) enabled() bool {
+	return limit.Rate > 0 && limit.Burst > 0
+}
+
+type RateLimitConfig struct {
+	// Limit of all requests of a single connection.
+	Connection RateLimit
+	// Limit of all requests of a single user across connections.
+	User RateLimit
+	// Limits of a single method per connection, by method name, e.g. "chat.channelSend".
+	Methods map[string]RateLimit
+	// Clients exceeding the limits this many times within the window are disconnected. Disabled if zero.
+	MaxViolations int
+	// Window to count the violations within.
+	ViolationWindow time.Duration
+}
+
+func readRateLimit(key string) RateLimit {
+	return RateLimit{
+		Rate:  config.GetFloat64(key + ".rate"),
+		Burst: config.GetInt(key + ".burst"),
+	}
+}
+
+// Reads the "rpc.rateLimit" configuration section. Method limits are read for every registered method,
+// e.g. "rpc.rateLimit.methods.chat.channelSend.rate".
+func newRateLimitConfigFromConfig(methods []websocketMethod) *RateLimitConfig {
+	rateLimits := &RateLimitConfig{
+		Connection:      readRateLimit("rpc.rateLimit.connection"),
+		User:            readRateLimit("rpc.rateLimit.user"),
+		Methods:         make(map[string]RateLimit),
+		MaxViolations:   config.GetInt("rpc.rateLimit.maxViolations"),
+		ViolationWindow: config.GetDuration("rpc.rateLimit.violationWindow"),
+	}
+
+	for _, m := range methods {
+		name := getMethodName(m.Topic, m.Method)
+		if limit := readRateLimit("rpc.rateLimit.methods." + name); limit.enabled() {
+			rateLimits.Methods[name] = limit
+		}
+	}
+
+	return rateLimits
+}
+
+// Rate limit state of a single connection.
+type clientRateLimiter struct {
+	lock       sync.Mutex
+	connection *tokenBucket
+	methods    map[string]*tokenBucket
+	violations []time.Time
+}
+
+func newClientRateLimiter() *clientRateLimiter {
+	return &clientRateLimiter{
+		methods: make(map[string]*tokenBucket),
+	}
+}
+
+func (limiter *clientRateLimiter) takeConnection(limit RateLimit, now time.Time) (bool, time.Duration) {
+	limiter.lock.Lock()
+	if limiter.connection == nil {
+		limiter.connection = newTokenBucket(limit, now)
+	}
+	bucket := limiter.connection
+	limiter.lock.Unlock()
+
+	return bucket.take(now)
+}
+
+func (limiter *clientRateLimiter) takeMethod(name string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	limiter.lock.Lock()
+	bucket, ok := limiter.methods[name]
+	if !ok {
+		bucket = newTokenBucket(limit, now)
+		limiter.methods[name] = bucket
+	}
+	limiter.lock.Unlock()
+
+	return bucket.take(now)
+}
+
+// Records the violation and returns the number of violations within the window.
+func (limiter *clientRateLimiter) addViolation(window time.Duration, now time.Time) int {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	recent := limiter.violations[:0]
+	for _, at := range limiter.violations {
+		if now.Sub(at) <= window {
+			recent = append(recent, at)
+		}
+	}
+	limiter.violations = append(recent, now)
+
+	return len(limiter.violations)
+}
+
+// Rate limit state of the users shared by all their connections.
+type userRateLimiter struct {
+	lock     sync.Mutex
+	buckets  map[uuid.UUID]*tokenBucket
+	prunedAt time.Time
+}
+
+func newUserRateLimiter() *userRateLimiter {
+	return &userRateLimiter{
+		buckets: make(map[uuid.UUID]*tokenBucket),
+	}
+}
+
+func (limiter *userRateLimiter) take(userId uuid.UUID, limit RateLimit, now time.Time) (bool, time.Duration) {
+	limiter.lock.Lock()
+	if now.Sub(limiter.prunedAt) > userRateLimitIdleTimeout {
+		for id, b := range limiter.buckets {
+			if now.Sub(b.idleSince()) > userRateLimitIdleTimeout {
+				delete(limiter.buckets, id)
+			}
+		}
+		limiter.prunedAt = now
+	}
+
+	bucket, ok := limiter.buckets[userId]
+	if !ok {
+		bucket = newTokenBucket(limit, now)
+		limiter.buckets[userId] = bucket
+	}
+	limiter.lock.Unlock()
+
+	return bucket.take(now)
+}
+
+// Checks the connection, user and method limits. Returns the exceeded scope and the time until the next request is allowed.
+func (client *WebsocketClient) takeRateLimit(rateLimits *RateLimitConfig, topic WebsocketTopic, method string, now time.Time) (string, time.Duration, bool) {
+	if rateLimits.Connection.enabled() {
+		if ok, retryAfter := client.rateLimiter.takeConnection(rateLimits.Connection, now); !ok {
+			return rateLimitScopeConnection, retryAfter, false
+		}
+	}
+
+	if rateLimits.User.enabled() && client.user != nil {
+		if ok, retryAfter := client.server.userRateLimiter.take(client.user.Id, rateLimits.User, now); !ok {
+			return rateLimitScopeUser, retryAfter, false
+		}
+	}
+
+	name := getMethodName(topic, method)
+	if limit, ok := rateLimits.Methods[name]; ok {
+		if ok, retryAfter := client.rateLimiter.takeMethod(name, limit, now); !ok {
+			return rateLimitScopeMethod, retryAfter, false
+		}
+	}
+
+	return "", 0, true
+}
+
+// Rejects calls exceeding the rate limits and disconnects clients which keep exceeding them.
+func rpcRateLimitMiddleware(next websocketRequestHandler) websocketRequestHandler {
+	return func(client *WebsocketClient, message *WebsocketMessage, topic WebsocketTopic, method string, args interface{}) error {
+		rateLimits := client.server.rateLimits
+		if rateLimits == nil {
+			return next(client, message, topic, method, args)
+		}
+
+		now := time.Now()
+
+		scope, retryAfter, ok := client.takeRateLimit(rateLimits, topic, method, now)
+		if ok {
+			return next(client, message, topic, method, args)
+		}
+
+		err := client.sendErrorResponseWithDetails(message, ErrorCodeRateLimited,
+			fmt.Errorf("%s rate limit exceeded by method %s", scope, getMethodName(topic, method)),
+			map[string]interface{}{"scope": scope, "retryAfterMs": retryAfter.Milliseconds()})
+
+		if rateLimits.MaxViolations > 0 {
+			if violations := client.rateLimiter.addViolation(rateLimits.ViolationWindow, now); violations >= rateLimits.MaxViolations {
+				client.disconnect(websocket.ClosePolicyViolation, "rate limit exceeded")
+			}
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,65 @@
+package web
+
+import (
+	"fmt"
+)
+
+// Wraps the request handler with cross-cutting behavior, e.g. logging or authentication.
+type websocketMiddleware func(next websocketRequestHandler) websocketRequestHandler
+
+type websocketMethodKey struct {
+	topic  WebsocketTopic
+	method string
+}
+
+// Process-wide registry of the RPC methods implemented by the server.
+type websocketRegistry struct {
+	// Registered methods in the order of registration.
+	methods []websocketMethod
+	// Method handlers wrapped with the method and registry middleware.
+	handlers map[websocketMethodKey]websocketRequestHandler
+	// Middleware applied to every method call, the first one is the outermost.
+	middleware []websocketMiddleware
+}
+
+func newWebsocketRegistry(middleware ...websocketMiddleware) *websocketRegistry {
+	return &websocketRegistry{
+		handlers:   make(map[websocketMethodKey]websocketRequestHandler),
+		middleware: middleware,
+	}
+}
+
+// Registers the method. Panics if the method has already been registered for the topic.
+func (registry *websocketRegistry) register(methods ...websocketMethod) {
+	for _, m := range methods {
+		key := websocketMethodKey{topic: m.Topic, method: m.Method}
+		if _, ok := registry.handlers[key]; ok {
+			panic(fmt.Sprintf("rpc method %s is registered twice", getMethodName(m.Topic, m.Method)))
+		}
+
+		handler := chainMiddleware(m.Handler, m.Middleware...)
+		handler = chainMiddleware(handler, registry.middleware...)
+
+		registry.handlers[key] = handler
+		registry.methods = append(registry.methods, m)
+	}
+}
+
+// Finds the handler registered for the topic and method.
+func (registry *websocketRegistry) lookup(topic WebsocketTopic, method string) (websocketRequestHandler, bool) {
+	handler, ok := registry.handlers[websocketMethodKey{topic: topic, method: method}]
+	return handler, ok
+}
+
+// Wraps the handler with the middleware, the first middleware becomes the outermost.
+func chainMiddleware(handler websocketRequestHandler, middleware ...websocketMiddleware) websocketRequestHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Name of the method used in logs and the schema, e.g. "chat.channelSend".
+func getMethodName(topic WebsocketTopic, method string) string {
+	return fmt.Sprintf("%s.%s", getTopicName(topic), method)
+}
@@ -0,0 +1,236 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrRequestTimeout  = errors.New("rpc request timed out")
+	ErrRequestCanceled = errors.New("rpc request canceled")
+)
+
+// Error returned to the caller when a server-to-client request does not complete.
+type WebsocketRequestError struct {
+	Id     uuid.UUID
+	Topic  WebsocketTopic
+	Method string
+	// Either ErrRequestTimeout or ErrRequestCanceled.
+	Err error
+}
+
+func (e *WebsocketRequestError) Error() string {
+	return fmt.Sprintf("%s: id: %s, topic: %d, method: %s", e.Err.Error(), e.Id, e.Topic, e.Method)
+}
+
+func (e *WebsocketRequestError) Unwrap() error {
+	return e.Err
+}
+
+// Outcome of the server-to-client request.
+type websocketRequestResult struct {
+	response *WebsocketMessage
+	err      error
+}
+
+// Server-to-client request awaiting for the response.
+type websocketPendingRequest struct {
+	topic     WebsocketTopic
+	method    string
+	expiresAt time.Time
+	// Receives exactly one result, buffered to never block the reader goroutine.
+	result chan websocketRequestResult
+}
+
+// Registers the request for response routing and timeout detection.
+func (client *WebsocketClient) addPendingRequest(request *WebsocketMessage, expiresAt time.Time) *websocketPendingRequest {
+	pending := &websocketPendingRequest{
+		topic:     request.Topic,
+		method:    request.Method,
+		expiresAt: expiresAt,
+		result:    make(chan websocketRequestResult, 1),
+	}
+
+	client.requestsLock.Lock()
+	client.requests[request.Id] = pending
+	client.requestsLock.Unlock()
+
+	return pending
+}
+
+// Removes the request and delivers the result to the caller if the request still was pending.
+func (client *WebsocketClient) completePendingRequest(id uuid.UUID, result websocketRequestResult) bool {
+	client.requestsLock.Lock()
+	pending, ok := client.requests[id]
+	if ok {
+		delete(client.requests, id)
+	}
+	client.requestsLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if result.err != nil {
+		result.err = &WebsocketRequestError{Id: id, Topic: pending.topic, Method: pending.method, Err: result.err}
+	}
+
+	pending.result <- result
+
+	return true
+}
+
+// Fails all the requests which deadline has passed.
+func (client *WebsocketClient) expirePendingRequests(now time.Time) {
+	var expired []uuid.UUID
+
+	client.requestsLock.Lock()
+	for id, pending := range client.requests {
+		if now.After(pending.expiresAt) {
+			expired = append(expired, id)
+		}
+	}
+	client.requestsLock.Unlock()
+
+	for _, id := range expired {
+		if client.completePendingRequest(id, websocketRequestResult{err: ErrRequestTimeout}) {
+			log.Errorf("got an timeout processing request: %s", id)
+		}
+	}
+}
+
+// Fails all the pending requests, called when the client disconnects.
+func (client *WebsocketClient) cancelPendingRequests() {
+	var canceled []uuid.UUID
+
+	client.requestsLock.Lock()
+	for id := range client.requests {
+		canceled = append(canceled, id)
+	}
+	client.requestsLock.Unlock()
+
+	for _, id := range canceled {
+		client.completePendingRequest(id, websocketRequestResult{err: ErrRequestCanceled})
+	}
+}
+
+// Calls the client method and waits for the response payload.
+// Returns the WebsocketRequestError wrapping ErrRequestTimeout if the client does not respond in time, or
+// ErrRequestCanceled if the context is canceled or the client disconnects before responding.
+// If the context has no deadline, the request times out after the pong wait period.
+func (client *WebsocketClient) Call(ctx context.Context, topic WebsocketTopic, method string, args interface{}) (interface{}, error) {
+	expiresAt, ok := ctx.Deadline()
+	if !ok {
+		expiresAt = time.Now().Add(pongWait)
+	}
+
+	request := WebsocketMessage{
+		Id:     uuid.New(),
+		Type:   RequestMessageType,
+		Topic:  topic,
+		Method: method,
+		Args:   args,
+	}
+
+	pending := client.addPendingRequest(&request, expiresAt)
+
+	if err := client.writeRequestMessage(&request); err != nil {
+		client.completePendingRequest(request.Id, websocketRequestResult{err: ErrRequestCanceled})
+		return nil, err
+	}
+
+	timer := time.NewTimer(time.Until(expiresAt))
+	defer timer.Stop()
+
+	select {
+	case result := <-pending.result:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return result.response.Payload, nil
+	case <-timer.C:
+		client.completePendingRequest(request.Id, websocketRequestResult{err: ErrRequestTimeout})
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			client.completePendingRequest(request.Id, websocketRequestResult{err: ErrRequestTimeout})
+		} else {
+			client.completePendingRequest(request.Id, websocketRequestResult{err: ErrRequestCanceled})
+		}
+	}
+
+	// The result is either the failure set above or the response which has arrived concurrently.
+	result := <-pending.result
+	if result.err != nil {
+		return nil, result.err
+	}
+	return result.response.Payload, nil
+}
+
+// Decodes the loosely typed response payload into the value pointed by out.
+func decodeResponsePayload(payload interface{}, out interface{}) error {
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonBody, out)
+}
+
+//region client requests
+
+type TeleportConfirmArgs struct {
+	SpaceId  uuid.UUID `json:"spaceId"`
+	ServerId uuid.UUID `json:"serverId,omitempty"`
+	Sender   string    `json:"sender,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+type TeleportConfirmResult struct {
+	Accepted bool `json:"accepted"`
+}
+
+// Asks the client to confirm the teleport. The player is expected to answer before the context deadline.
+func (client *WebsocketClient) RequestTeleportConfirmation(ctx context.Context, args TeleportConfirmArgs) (bool, error) {
+	payload, err := client.Call(ctx, SystemTopic, TeleportConfirmMethod, args)
+	if err != nil {
+		return false, err
+	}
+
+	var result TeleportConfirmResult
+	if err = decodeResponsePayload(payload, &result); err != nil {
+		return false, err
+	}
+
+	return result.Accepted, nil
+}
+
+type LocalStateReport struct {
+	SpaceId  uuid.UUID              `json:"spaceId,omitempty"`
+	ServerId uuid.UUID              `json:"serverId,omitempty"`
+	Location []float64              `json:"location,omitempty"`
+	Rotation []float64              `json:"rotation,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// Asks the client to report its local state.
+func (client *WebsocketClient) RequestLocalState(ctx context.Context) (*LocalStateReport, error) {
+	payload, err := client.Call(ctx, SystemTopic, LocalStateReportMethod, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var report LocalStateReport
+	if err = decodeResponsePayload(payload, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+//endregion client requests
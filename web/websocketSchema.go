@@ -0,0 +1,142 @@
+package web
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Topic names used in the schema definitions.
+var websocketTopicNames = map[WebsocketTopic]string{
+	SystemTopic:    "system",
+	ChatTopic:      "chat",
+	AnalyticsTopic: "analytics",
+	VivoxTopic:     "vivox",
+}
+
+func getTopicName(topic WebsocketTopic) string {
+	if name, ok := websocketTopicNames[topic]; ok {
+		return name
+	}
+	return strconv.Itoa(int(topic))
+}
+
+type jsonSchema map[string]interface{}
+
+// Generates the JSON Schema of the request messages for all the RPC methods implemented by the server.
+func generateProtocolSchema(methods []websocketMethod) jsonSchema {
+	definitions := jsonSchema{}
+	requests := make([]interface{}, 0, len(methods))
+
+	for _, m := range methods {
+		name := getMethodName(m.Topic, m.Method)
+
+		definitions[name] = jsonSchema{
+			"title":       name,
+			"description": m.Description,
+			"type":        "object",
+			"properties": jsonSchema{
+				"id":     jsonSchema{"type": "string", "format": "uuid"},
+				"type":   jsonSchema{"const": RequestMessageType},
+				"topic":  jsonSchema{"const": m.Topic},
+				"method": jsonSchema{"const": m.Method},
+				"args":   generateTypeSchema(m.Args),
+			},
+			"required": []string{"id", "type", "topic", "method"},
+		}
+
+		requests = append(requests, jsonSchema{"$ref": "#/definitions/" + name})
+	}
+
+	return jsonSchema{
+		"$schema":     jsonSchemaDraft,
+		"title":       "Artheon RPC requests",
+		"oneOf":       requests,
+		"definitions": definitions,
+	}
+}
+
+// Generates the schema of the args type using json and validate struct tags.
+func generateTypeSchema(t reflect.Type) jsonSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == uuidType {
+		return jsonSchema{"type": "string", "format": "uuid"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": generateTypeSchema(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": generateTypeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := jsonSchema{}
+		required := make([]string, 0)
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := getArgName(field)
+			if !ok {
+				continue
+			}
+
+			property := generateTypeSchema(field.Type)
+
+			for _, rule := range getArgRules(field) {
+				applyRuleSchema(property, rule)
+				if rule.name == "required" {
+					required = append(required, name)
+				}
+			}
+
+			properties[name] = property
+		}
+
+		schema := jsonSchema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+
+	// Loosely typed values, e.g. interface{}.
+	return jsonSchema{}
+}
+
+func applyRuleSchema(schema jsonSchema, rule argRule) {
+	switch rule.name {
+	case "required":
+		if schema["type"] == "string" {
+			schema["minLength"] = 1
+		}
+	case "uuid":
+		schema["format"] = "uuid"
+	case "min", "max":
+		limit, err := strconv.Atoi(rule.param)
+		if err != nil {
+			return
+		}
+		keyword := map[string]map[interface{}]string{
+			"min": {"string": "minLength", "array": "minItems", "integer": "minimum", "number": "minimum"},
+			"max": {"string": "maxLength", "array": "maxItems", "integer": "maximum", "number": "maximum"},
+		}[rule.name][schema["type"]]
+		if keyword != "" {
+			schema[keyword] = limit
+		}
+	case "oneof":
+		schema["enum"] = strings.Split(rule.param, "|")
+	}
+}
@@ -1,12 +1,18 @@
 package web
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"dev.hackerman.me/artheon/artheon-rpc/models"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 )
@@ -14,22 +20,14 @@ import (
 const SystemChannelId string = "XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX"
 const GlobalChannelId string = "XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX"
 
-// Websocket server instance.
-var WebsocketServerInstance *WebsocketServer
+// Reason of the close frames sent to the clients on shutdown.
+const shutdownCloseReason = "server restarting"
 
-// Start websocket server.
-func init() {
-	WebsocketServerInstance = newWebsocketServer()
-	go WebsocketServerInstance.start()
-}
-
-type PrivateChannelInfo struct {
-	// Id of the user who initiated the private channel
-	Host uuid.UUID
+// Namespace of the private channel ids derived from the user ids.
+var privateChannelNamespace = uuid.MustParse("83c830b6-3a4e-4169-a29d-2edfd2ccf7e0")
 
-	// Id of the user invited to the private channel
-	Guest uuid.UUID
-}
+// Period of checking if all the clients have disconnected on shutdown.
+const shutdownPollPeriod = 100 * time.Millisecond
 
 type ChannelInfo struct {
 	// Global channel
@@ -37,35 +35,36 @@ type ChannelInfo struct {
 
 	// Global channel
 	GeneralChannel uuid.UUID
-
-	// Registered space channels.
-	SpaceChannels []uuid.UUID
-
-	// Registered server channels.
-	ServerChannels []uuid.UUID
-
-	// Registered user channels, allows up to 2 users to join for private conversation.
-	PrivateChannels map[uuid.UUID]PrivateChannelInfo
 }
 
-// A single instance at the server side.
+// A single instance at the server side. Clients and channels are accessed from the goroutines of all the clients,
+// so they are guarded by the locks and must be accessed with the server methods.
 type WebsocketServer struct {
 	ChannelInfo
 
-	// Known users
-	Users map[uuid.UUID]models.User
+	// Registered space, server and private channels with their subscribers
+	channels *websocketChannelRegistry
+
+	// Devices of the connected users
+	users *websocketUserRegistry
 
-	// Known spaces
-	Spaces map[uuid.UUID]models.Space
+	// Database with the caches of the users, spaces and servers
+	store *models.Store
 
-	// Database connection
-	Db *sql.DB
+	// Issues the Vivox tokens
+	vivox *models.VivoxClient
+
+	// Unique ID of this server instance.
+	instanceId uuid.UUID
+
+	// Delivers the messages to the clients connected to the other instances.
+	backplane Backplane
 
 	// Registered clients.
 	Clients map[uuid.UUID]*WebsocketClient
 
-	// Channel to broadcast system messages to all clients.
-	broadcast chan []byte
+	// Guards Clients
+	clientsLock sync.RWMutex
 
 	// Register requests from the clients.
 	register chan *WebsocketClient
@@ -73,50 +72,303 @@ type WebsocketServer struct {
 	// Unregister requests from the clients.
 	unregister chan *WebsocketClient
 
-	// Message serializer
-	serializer *WebsocketMessageSerializer
+	// Session token authenticator
+	authenticator Authenticator
+
+	// Request rate limits, disabled if nil
+	rateLimits *RateLimitConfig
+
+	// Request rate limit state of the users
+	userRateLimiter *userRateLimiter
+
+	// Resumable sessions of the clients
+	sessions *websocketSessionStore
+
+	// Outbound queue size and slow consumer policy of the clients
+	outbound OutboundConfig
+
+	// Chat settings
+	chat ChatConfig
+
+	// Connection limits and counters
+	admission *connectionAdmission
+
+	// Set when the server is shutting down and must not accept new clients.
+	draining atomic.Bool
+
+	// Outbound queue counters of the unregistered clients.
+	outboundDropped       atomic.Uint64
+	outboundHighWaterMark atomic.Int64
+
+	// Closed to stop the hub loop.
+	done chan struct{}
+
+	// Closed when the hub loop has stopped.
+	stopped chan struct{}
+}
+
+// Settings and dependencies of the websocket server.
+type WebsocketServerConfig struct {
+	// Verifies the session tokens of the clients. Clients can not connect if nil.
+	Authenticator Authenticator
+	// Request rate limits, disabled if nil.
+	RateLimits *RateLimitConfig
+	// Resumable sessions of the disconnected clients.
+	Session WebsocketSessionSettings
+	// Outbound queue size and slow consumer policy of the clients.
+	Outbound OutboundConfig
+	// Connection limits.
+	Admission AdmissionConfig
+	// Chat settings.
+	Chat ChatConfig
+	// Delivers the messages to the other instances. The in-memory backplane is used if nil.
+	Backplane Backplane
 }
 
-func newWebsocketServer() *WebsocketServer {
+// Reads the websocket server configuration. The backplane, if configured, uses the database.
+func NewWebsocketServerConfigFromConfig(db *sql.DB, dbUrl string) (WebsocketServerConfig, error) {
+	backplane, err := newBackplaneFromConfig(db, dbUrl)
+	if err != nil {
+		return WebsocketServerConfig{}, err
+	}
+
+	return WebsocketServerConfig{
+		Authenticator: newJwtAuthenticatorFromConfig(),
+		RateLimits:    newRateLimitConfigFromConfig(websocketRpcRegistry.methods),
+		Session:       newWebsocketSessionSettingsFromConfig(),
+		Outbound:      newOutboundConfigFromConfig(),
+		Admission:     newAdmissionConfigFromConfig(),
+		Chat:          newChatConfigFromConfig(),
+		Backplane:     backplane,
+	}, nil
+}
+
+// Creates the websocket server and starts its hub loop, stop it with Shutdown. The server closes the backplane on
+// shutdown, while the database is left to the caller.
+func NewWebsocketServer(config WebsocketServerConfig, db *sql.DB) (*WebsocketServer, error) {
+	switch config.Outbound.Policy {
+	case "", SlowConsumerDropOldest, SlowConsumerDisconnect:
+	default:
+		return nil, fmt.Errorf("unknown slow consumer policy: %s", config.Outbound.Policy)
+	}
+
 	server := &WebsocketServer{
 		ChannelInfo: ChannelInfo{
-			SystemChannel:   uuid.MustParse(SystemChannelId),
-			GeneralChannel:  uuid.MustParse(GlobalChannelId),
-			SpaceChannels:   make([]uuid.UUID, 0),
-			PrivateChannels: make(map[uuid.UUID]PrivateChannelInfo),
+			SystemChannel:  uuid.MustParse(SystemChannelId),
+			GeneralChannel: uuid.MustParse(GlobalChannelId),
 		},
+		channels:   newWebsocketChannelRegistry(),
+		users:      newWebsocketUserRegistry(),
+		store:      models.NewStore(db),
+		vivox:      models.NewVivoxClient(),
+		instanceId: uuid.New(),
 		Clients:    make(map[uuid.UUID]*WebsocketClient),
-		broadcast:  make(chan []byte),
 		register:   make(chan *WebsocketClient),
 		unregister: make(chan *WebsocketClient),
-		serializer: newWebsocketMessageSerializer(),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+
+		authenticator:   config.Authenticator,
+		rateLimits:      config.RateLimits,
+		userRateLimiter: newUserRateLimiter(),
+		sessions:        newWebsocketSessionStore(config.Session),
+		outbound:        config.Outbound,
+		admission:       newConnectionAdmission(config.Admission),
+		chat:            config.Chat,
+	}
+
+	server.channels.register(websocketChannelInfo{Id: server.SystemChannel, Category: CategorySystem})
+	server.channels.register(websocketChannelInfo{Id: server.GeneralChannel, Category: CategoryGeneral})
+
+	backplane := config.Backplane
+	if backplane == nil {
+		backplane = NewMemoryBackplane()
+	}
+
+	if err := server.useBackplane(backplane); err != nil {
+		return nil, err
 	}
 
-	return server
+	go server.start()
+
+	return server, nil
 }
 
 func (server *WebsocketServer) registerClient(client *WebsocketClient) {
+	server.clientsLock.Lock()
 	server.Clients[client.Id] = client
+	server.clientsLock.Unlock()
 }
 
 func (server *WebsocketServer) unregisterClient(client *WebsocketClient) {
-	if _, ok := server.Clients[client.Id]; ok {
+	server.clientsLock.Lock()
+	_, ok := server.Clients[client.Id]
+	if ok {
 		//client.closeWebsocket("unregister client")
 		delete(server.Clients, client.Id)
 	}
+	server.clientsLock.Unlock()
+
+	if ok {
+		server.admission.release(client.remoteIp)
+
+		// Stop the write goroutine and reject new subscriptions.
+		client.send.close()
+
+		client.detachSession(time.Now())
+		server.channels.removeClient(client, client.getChannels())
+
+		// Presence of the user is computed across the remaining devices. The presence of the last device is kept,
+		// as the client may resume the session, until the session expires.
+		if user := client.getUser(); user != nil {
+			if server.users.remove(user.Id, client) > 0 {
+				go server.refreshUserPresence(user)
+			}
+		}
+
+		stats := client.GetOutboundStats()
+		server.outboundDropped.Add(stats.Dropped)
+		server.updateOutboundHighWaterMark(stats.HighWaterMark)
+		log.Printf("client {%s} unregistered, outbound messages dropped: {%d}, high water mark: {%d}, rtt: {%s}", client.Id, stats.Dropped, stats.HighWaterMark, client.getRtt())
+	}
+}
+
+func (server *WebsocketServer) updateOutboundHighWaterMark(highWaterMark int) {
+	for {
+		current := server.outboundHighWaterMark.Load()
+		if int64(highWaterMark) <= current || server.outboundHighWaterMark.CompareAndSwap(current, int64(highWaterMark)) {
+			return
+		}
+	}
+}
+
+// Counters of the websocket server.
+type WebsocketServerStats struct {
+	Clients int `json:"clients"` // Number of the registered clients.
+	// Outbound queue counters summed over the registered clients. The dropped messages and the high water mark also
+	// include the clients unregistered since the start.
+	Outbound OutboundQueueStats `json:"outbound"`
+}
+
+// Returns the counters of the server, e.g. to export them as metrics.
+func (server *WebsocketServer) Stats() WebsocketServerStats {
+	clients := server.getClients()
+
+	stats := WebsocketServerStats{
+		Clients: len(clients),
+		Outbound: OutboundQueueStats{
+			Dropped:       server.outboundDropped.Load(),
+			HighWaterMark: int(server.outboundHighWaterMark.Load()),
+		},
+	}
+
+	for _, client := range clients {
+		clientStats := client.GetOutboundStats()
+		stats.Outbound.Length += clientStats.Length
+		stats.Outbound.Dropped += clientStats.Dropped
+		if clientStats.HighWaterMark > stats.Outbound.HighWaterMark {
+			stats.Outbound.HighWaterMark = clientStats.HighWaterMark
+		}
+	}
+
+	return stats
 }
 
-func (server *WebsocketServer) broadcastMessage(message []byte) {
-	// Send a message to each client.
+// Returns the snapshot of the registered clients.
+func (server *WebsocketServer) getClients() []*WebsocketClient {
+	server.clientsLock.RLock()
+	defer server.clientsLock.RUnlock()
+
+	clients := make([]*WebsocketClient, 0, len(server.Clients))
 	for _, client := range server.Clients {
-		client.send <- message
+		clients = append(clients, client)
 	}
+	return clients
 }
 
-func (server *WebsocketServer) start() {
+//region channels
 
-	var err error
+func (server *WebsocketServer) isSpaceChannel(channelId uuid.UUID) bool {
+	return server.channels.getCategory(channelId) == CategorySpace
+}
+
+func (server *WebsocketServer) isServerChannel(channelId uuid.UUID) bool {
+	return server.channels.getCategory(channelId) == CategoryServer
+}
+
+// Registers the space channel if it is not registered yet.
+func (server *WebsocketServer) addSpaceChannel(channelId uuid.UUID, name string) {
+	server.channels.register(websocketChannelInfo{Id: channelId, Category: CategorySpace, Name: name})
+}
+
+// Registers the server channel if it is not registered yet.
+func (server *WebsocketServer) addServerChannel(channelId uuid.UUID, name string) {
+	server.channels.register(websocketChannelInfo{Id: channelId, Category: CategoryServer, Name: name})
+}
+
+// Derives the private channel id from the user ids, so all the instances agree on the channel of the users.
+func getPrivateChannelId(userId uuid.UUID, otherUserId uuid.UUID) uuid.UUID {
+	if bytes.Compare(userId[:], otherUserId[:]) > 0 {
+		userId, otherUserId = otherUserId, userId
+	}
+	return uuid.NewSHA1(privateChannelNamespace, append(userId[:], otherUserId[:]...))
+}
+
+// Registers the private channel of the users if it is not registered yet.
+func (server *WebsocketServer) addPrivateChannel(channelId uuid.UUID, hostId uuid.UUID, guestId uuid.UUID) {
+	server.channels.register(websocketChannelInfo{Id: channelId, Category: CategoryPrivate, Members: []uuid.UUID{hostId, guestId}})
+}
+
+// Registers the private channel and subscribes all the devices of the host and the guest connected to this instance.
+func (server *WebsocketServer) joinPrivateChannel(channelId uuid.UUID, hostId uuid.UUID, guestId uuid.UUID) {
+	server.addPrivateChannel(channelId, hostId, guestId)
+
+	for _, userId := range []uuid.UUID{hostId, guestId} {
+		for _, client := range server.users.addPrivateChannel(userId, channelId) {
+			client.addChannelSubscription(channelId)
+		}
+	}
+}
+
+// Registers the private channels of the user stored in the database, so they survive restarts and can be opened with
+// the users who are offline. Returns the channel ids.
+func (server *WebsocketServer) restorePrivateChannels(userId uuid.UUID) []uuid.UUID {
+	channels, err := server.store.GetPrivateChannelsByUserId(userId)
+	if err != nil {
+		log.Errorf("got an error getting private channels of user {%s}: %s", userId, err.Error())
+		return nil
+	}
+
+	channelIds := make([]uuid.UUID, 0, len(channels))
+	for _, channel := range channels {
+		server.addPrivateChannel(channel.Id, channel.HostId, channel.GuestId)
+		server.users.addPrivateChannel(userId, channel.Id)
+		channelIds = append(channelIds, channel.Id)
+	}
+
+	return channelIds
+}
+
+// Records the messages of the private channels of the user not delivered yet as delivered, e.g. after they have been
+// replayed within the resumed session.
+func (server *WebsocketServer) markPrivateChannelsDelivered(userId uuid.UUID) {
+	channels, err := server.store.GetPrivateChannelsByUserId(userId)
+	if err != nil {
+		log.Errorf("got an error getting private channels of user {%s}: %s", userId, err.Error())
+		return
+	}
 
+	for _, channel := range channels {
+		if err = server.store.AddPrivateChannelDelivered(channel.Id, userId); err != nil {
+			log.Errorf("got an error marking private channel {%s} of user {%s} delivered: %s", channel.Id, userId, err.Error())
+		}
+	}
+}
+
+//endregion channels
+
+// Builds the database url from the DB_USER, DB_PASS, DB_HOST, DB_PORT and DB_NAME environment variables.
+func GetDatabaseUrl() string {
 	db_user := os.Getenv("DB_USER")
 	if db_user == "" {
 		db_user = "postgres"
@@ -137,17 +389,23 @@ func (server *WebsocketServer) start() {
 	if db_name == "" {
 		db_name = "veverse"
 	}
-	db_url := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable", db_user, db_pass, db_host, db_port, db_name)
+	return fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable", db_user, db_pass, db_host, db_port, db_name)
+}
 
-	if server.Db, err = sql.Open("postgres", db_url); err != nil {
-		log.Fatal(err)
-	}
+func OpenDatabase(dbUrl string) (*sql.DB, error) {
+	return sql.Open("postgres", dbUrl)
+}
 
+func (server *WebsocketServer) start() {
 	//goland:noinspection GoUnhandledErrorResult
 	defer func() {
-		server.Db.Close()
+		server.backplane.Close()
+		close(server.stopped)
 	}()
 
+	sessionTicker := time.NewTicker(pingPeriod)
+	defer sessionTicker.Stop()
+
 	for {
 		select {
 		// Register a client.
@@ -158,9 +416,57 @@ func (server *WebsocketServer) start() {
 		case client := <-server.unregister:
 			server.unregisterClient(client)
 
-		// On message.
-		case message := <-server.broadcast:
-			server.broadcastMessage(message)
+		// Drop expired sessions, the users without the other devices go offline.
+		case now := <-sessionTicker.C:
+			for _, session := range server.sessions.prune(now) {
+				server.channels.removeSession(session, session.getChannels())
+				go server.expireUserPresence(session.userId)
+			}
+
+		// Stop on shutdown.
+		case <-server.done:
+			return
+		}
+	}
+}
+
+func (server *WebsocketServer) isDraining() bool {
+	return server.draining.Load()
+}
+
+// Stops accepting new clients and asks the clients to close the connections, so they reconnect to the other
+// instances. Waits for the clients to disconnect until the context is done, then closes the remaining connections
+// and stops the hub loop.
+func (server *WebsocketServer) Shutdown(ctx context.Context) error {
+	server.draining.Store(true)
+
+	clients := server.getClients()
+	log.Infof("draining websocket clients: {%d}", len(clients))
+
+	for _, client := range clients {
+		client.sendClose(websocket.CloseServiceRestart, shutdownCloseReason)
+	}
+
+	ticker := time.NewTicker(shutdownPollPeriod)
+	defer ticker.Stop()
+
+	var err error
+	for err == nil && len(server.getClients()) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
 		}
 	}
+
+	// Close connections of the clients which have not completed the close handshake in time.
+	for _, client := range server.getClients() {
+		log.Warnf("closing websocket client {%s} after the drain timeout", client.Id)
+		_ = client.conn.Close()
+	}
+
+	close(server.done)
+	<-server.stopped
+
+	return err
 }
@@ -0,0 +1,337 @@
+package web
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Builds the server without the database and the hub loop, the system channel placeholders are replaced by random ids.
+// The store queries fail at once.
+func newTestWebsocketServer() *WebsocketServer {
+	db, _ := sql.Open("postgres", "")
+	_ = db.Close()
+
+	server := &WebsocketServer{
+		ChannelInfo: ChannelInfo{
+			SystemChannel:  uuid.New(),
+			GeneralChannel: uuid.New(),
+		},
+		store:           models.NewStore(db),
+		channels:        newWebsocketChannelRegistry(),
+		users:           newWebsocketUserRegistry(),
+		instanceId:      uuid.New(),
+		Clients:         make(map[uuid.UUID]*WebsocketClient),
+		userRateLimiter: newUserRateLimiter(),
+		sessions:        newWebsocketSessionStore(WebsocketSessionSettings{}),
+		outbound:        OutboundConfig{QueueSize: 16, Policy: SlowConsumerDropOldest},
+		admission:       newConnectionAdmission(AdmissionConfig{}),
+		backplane:       NewMemoryBackplane(),
+	}
+
+	server.channels.register(websocketChannelInfo{Id: server.SystemChannel, Category: CategorySystem})
+	server.channels.register(websocketChannelInfo{Id: server.GeneralChannel, Category: CategoryGeneral})
+
+	return server
+}
+
+// Builds the client of the user without the websocket connection and registers it as a device of the user.
+func newTestWebsocketClient(t *testing.T, server *WebsocketServer, user *models.User) *WebsocketClient {
+	client := &WebsocketClient{
+		Id:            uuid.New(),
+		server:        server,
+		serializer:    getWebsocketMessageSerializer(""),
+		send:          newOutboundQueue(server.outbound.QueueSize),
+		requests:      make(map[uuid.UUID]*websocketPendingRequest),
+		disconnecting: make(chan bool, 1),
+	}
+	client.setUser(user)
+
+	if _, err := server.users.add(user.Id, client, 0); err != nil {
+		t.Fatalf("failed to add the client of user {%s}: %s", user.Id, err.Error())
+	}
+	server.registerClient(client)
+
+	return client
+}
+
+// Opens the websocket connection to the test server, which reads the messages until the connection is closed.
+func newTestWebsocketConn(t *testing.T) *websocket.Conn {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect to the test server: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+// Drains the outbound messages of the client until the stop channel is closed, as the write goroutine does.
+func drainTestWebsocketClient(client *WebsocketClient, stop chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-client.send.notify:
+				client.send.pop(outboundWriteBatchSize)
+			}
+		}
+	}()
+}
+
+func quietTestLog() func() {
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	return func() { log.SetLevel(level) }
+}
+
+// Subscribes and unsubscribes hundreds of clients from their read goroutines, half of which then disconnect, while the
+// other goroutines broadcast and multicast to the channels. Run with -race.
+func TestWebsocketServerConcurrentSubscriptionsAndUnregister(t *testing.T) {
+	const (
+		userCount      = 100
+		devicesPerUser = 3
+		iterations     = 50
+	)
+
+	defer quietTestLog()()
+
+	server := newTestWebsocketServer()
+
+	channels := make([]uuid.UUID, 8)
+	for i := range channels {
+		channels[i] = uuid.New()
+	}
+
+	userIds := make([]uuid.UUID, userCount)
+	clients := make([]*WebsocketClient, 0, userCount*devicesPerUser)
+	for i := range userIds {
+		userIds[i] = uuid.New()
+		for j := 0; j < devicesPerUser; j++ {
+			// Each connection loads its own copy of the user.
+			clients = append(clients, newTestWebsocketClient(t, server, &models.User{Id: userIds[i]}))
+		}
+	}
+
+	// Half of the clients have sessions, so unregistering them detaches the sessions.
+	for i := 0; i < len(clients); i += 2 {
+		if _, err := clients[i].startSession(); err != nil {
+			t.Fatalf("failed to start the session of client {%s}: %s", clients[i].Id, err.Error())
+		}
+	}
+
+	var wg, writers sync.WaitGroup
+	stop := make(chan struct{})
+
+	for _, client := range clients {
+		drainTestWebsocketClient(client, stop, &writers)
+	}
+
+	// Half of the clients disconnect while the messages are sent.
+	unregistered := make(map[uuid.UUID]bool)
+	for i := 0; i < len(clients); i += 2 {
+		unregistered[clients[i].Id] = true
+	}
+
+	// Read goroutines of the clients.
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *WebsocketClient) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				channelId := channels[(i+n)%len(channels)]
+				client.addChannelSubscription(channelId)
+				_ = client.isSubscribed(channelId)
+				for k := 0; k < len(channels); k++ {
+					_ = len(client.getChannels())
+					// Let the other goroutines change the channels between the reads.
+					runtime.Gosched()
+				}
+				client.removeChannelSubscription(channelId)
+			}
+			client.addChannelSubscription(channels[i%len(channels)])
+
+			if unregistered[client.Id] {
+				server.unregisterClient(client)
+			}
+		}(i, client)
+	}
+
+	// Messages broadcast and multicast to the channels.
+	for _, channelId := range channels {
+		wg.Add(2)
+		go func(channelId uuid.UUID) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				server.broadcastMessageToChannel(channelId, WebsocketPayload{Message: "message"})
+			}
+		}(channelId)
+		go func(channelId uuid.UUID) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				server.multicastMessageToChannel(userIds[n%userCount:], channelId, WebsocketPayload{Message: "message"})
+			}
+		}(channelId)
+	}
+
+	wg.Wait()
+	close(stop)
+	writers.Wait()
+
+	registered := make(map[uuid.UUID]bool)
+	for _, client := range server.getClients() {
+		registered[client.Id] = true
+	}
+
+	for i, client := range clients {
+		if registered[client.Id] == unregistered[client.Id] {
+			t.Errorf("client {%s} registered: {%t}, unregistered: {%t}", client.Id, registered[client.Id], unregistered[client.Id])
+		}
+		if session := client.getSession(); session != nil && !session.isDetached() {
+			t.Errorf("session of the unregistered client {%s} is still attached", client.Id)
+		}
+
+		subscribed := client.getChannels()
+		if len(subscribed) != 1 || subscribed[0] != channels[i%len(channels)] {
+			t.Errorf("client {%s} is subscribed for the channels {%v}", client.Id, subscribed)
+		}
+
+		for _, channelId := range channels {
+			subscribers, _ := server.channels.getSubscribers(channelId)
+			if containsClient(subscribers, client) != (!unregistered[client.Id] && channelId == channels[i%len(channels)]) {
+				t.Errorf("client {%s} is indexed as a subscriber of the channel {%s} inconsistently", client.Id, channelId)
+			}
+		}
+	}
+}
+
+// Pushes the messages to the sessions while their clients keep disconnecting and resuming the sessions with the new
+// connections. Run with -race.
+func TestWebsocketSessionConcurrentDetachAndResume(t *testing.T) {
+	const (
+		userCount  = 100
+		iterations = 50
+	)
+
+	defer quietTestLog()()
+
+	server := newTestWebsocketServer()
+	server.sessions = newWebsocketSessionStore(WebsocketSessionSettings{ResumeWindow: time.Minute, BufferSize: 2 * iterations})
+
+	var wg, writers sync.WaitGroup
+	stop := make(chan struct{})
+
+	channelId := uuid.New()
+	sessions := make([]*websocketSession, userCount)
+	lastClients := make([]*WebsocketClient, userCount)
+
+	for i := 0; i < userCount; i++ {
+		user := &models.User{Id: uuid.New()}
+
+		client := newTestWebsocketClient(t, server, user)
+		drainTestWebsocketClient(client, stop, &writers)
+		client.addChannelSubscription(channelId)
+
+		info, err := client.startSession()
+		if err != nil {
+			t.Fatalf("failed to start the session of client {%s}: %s", client.Id, err.Error())
+		}
+		sessions[i] = server.sessions.get(info.Token)
+
+		// Messages pushed to the session, attached or not.
+		wg.Add(1)
+		go func(session *websocketSession) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				// Fails if the client is being unregistered, the message is kept for the replay anyway.
+				_ = session.push(newPushMessage(ChatTopic, WebsocketPayload{Message: "message"}))
+				runtime.Gosched()
+			}
+		}(sessions[i])
+
+		// Connections dropped and resumed by the client.
+		wg.Add(1)
+		go func(i int, client *WebsocketClient, token string) {
+			defer wg.Done()
+			for n := 0; n < iterations/5; n++ {
+				server.unregisterClient(client)
+
+				client = newTestWebsocketClient(t, server, user)
+				drainTestWebsocketClient(client, stop, &writers)
+
+				request := &WebsocketMessage{Id: uuid.New(), Type: RequestMessageType, Topic: SystemTopic, Method: ConnectMethod}
+				if err := client.resumeSession(request, token, 0); err != nil {
+					t.Errorf("failed to resume the session of user {%s}: %s", user.Id, err.Error())
+					return
+				}
+			}
+			lastClients[i] = client
+		}(i, client, info.Token)
+	}
+
+	// Messages broadcast to the channel of the sessions, attached or not.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < iterations; n++ {
+			server.broadcastMessageToLocalChannel(channelId, WebsocketPayload{Message: "message"})
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	writers.Wait()
+
+	for i, session := range sessions {
+		session.lock.Lock()
+		if session.client != lastClients[i] {
+			t.Errorf("session of user {%s} is attached to the client other than the last one", session.userId)
+		}
+		if session.lastSeq != 2*iterations {
+			t.Errorf("session of user {%s} has last seq {%d}, expected {%d}", session.userId, session.lastSeq, 2*iterations)
+		}
+		session.lock.Unlock()
+
+		if lastClients[i] != nil && !containsUUID(lastClients[i].getChannels(), channelId) {
+			t.Errorf("client {%s} has not restored the channel subscriptions", lastClients[i].Id)
+		}
+	}
+}
+
+func containsClient(clients []*WebsocketClient, client *WebsocketClient) bool {
+	for _, v := range clients {
+		if v == client {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,389 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	config "github.com/spf13/viper"
+)
+
+const sessionTokenSize = 32
+
+var (
+	errSessionNotFound     = errors.New("session not found or expired")
+	errSessionUserMismatch = errors.New("session belongs to another user")
+	errSessionReplayGap    = errors.New("missed messages are no longer available")
+)
+
+// Session info returned by the connect handshake.
+type WebsocketSessionInfo struct {
+	Token   string `json:"token"`   // Opaque token used to resume the session after reconnect.
+	Resumed bool   `json:"resumed"` // Set if the existing session has been resumed.
+	LastSeq uint64 `json:"lastSeq"` // Sequence number of the last push message sent within the session.
+}
+
+// Session settings, read from the "rpc.session" configuration section.
+type WebsocketSessionSettings struct {
+	// Detached sessions are dropped after this period.
+	ResumeWindow time.Duration
+	// Maximal number of the unacknowledged push messages kept for retransmission and replay.
+	BufferSize int
+	// Unacknowledged push messages are retransmitted after this period.
+	AckTimeout time.Duration
+	// Number of retransmissions of a single message. Disabled if zero.
+	MaxRetransmits int
+}
+
+// Push message awaiting for the acknowledgement.
+type websocketSessionEntry struct {
+	message  WebsocketMessage
+	sentAt   time.Time
+	attempts int
+}
+
+// Resumable session of the authenticated client. Outlives the connection for the resume window, keeping the channel
+// subscriptions and the recent push messages to replay on reconnect.
+type websocketSession struct {
+	lock   sync.Mutex
+	token  string
+	userId uuid.UUID
+	// Attached client, nil while the session is detached.
+	client *WebsocketClient
+	// Channel subscriptions saved when the client detaches.
+	channels []uuid.UUID
+	// Device presence saved when the client detaches.
+	presence models.Presence
+	// Sequence number of the last push message.
+	lastSeq uint64
+	// Sequence number of the last push message acknowledged by the client.
+	ackedSeq uint64
+	// Set once the client acknowledges messages. Clients which never send acknowledgements get no retransmissions.
+	acking bool
+	// Unacknowledged push messages in the order of sequence numbers.
+	buffer     []websocketSessionEntry
+	settings   WebsocketSessionSettings
+	detachedAt time.Time
+}
+
+// Assigns the next sequence number to the push message, keeps it until acknowledged and sends it to the attached client.
+func (session *websocketSession) push(message WebsocketMessage) error {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	session.lastSeq++
+	message.Seq = session.lastSeq
+
+	session.buffer = append(session.buffer, websocketSessionEntry{message: message, sentAt: time.Now()})
+	if overflow := len(session.buffer) - session.settings.BufferSize; overflow > 0 {
+		if session.acking {
+			log.Warnf("dropping unacknowledged messages of user {%s}, count: {%d}", session.userId, overflow)
+		}
+		session.buffer = session.buffer[overflow:]
+	}
+
+	if session.client == nil {
+		return nil
+	}
+
+	return session.client.writePushMessage(&message)
+}
+
+// Drops the messages acknowledged by the client.
+func (session *websocketSession) acknowledge(seq uint64) {
+	if seq > session.lastSeq {
+		seq = session.lastSeq
+	}
+	if seq > session.ackedSeq {
+		session.ackedSeq = seq
+	}
+
+	i := 0
+	for i < len(session.buffer) && session.buffer[i].message.Seq <= session.ackedSeq {
+		i++
+	}
+	session.buffer = session.buffer[i:]
+}
+
+// Checks if all the messages after the sequence number are still buffered. Acknowledged messages are never replayed.
+func (session *websocketSession) canReplayFrom(seq uint64) bool {
+	if seq > session.lastSeq {
+		return false
+	}
+	if seq < session.ackedSeq {
+		seq = session.ackedSeq
+	}
+	if len(session.buffer) == 0 {
+		return seq == session.lastSeq
+	}
+	return seq+1 >= session.buffer[0].message.Seq
+}
+
+// Sends the messages unacknowledged within the timeout to the client again.
+func (session *websocketSession) retransmit(client *WebsocketClient, now time.Time) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if session.client != client || !session.acking {
+		return
+	}
+
+	for i := range session.buffer {
+		entry := &session.buffer[i]
+		if entry.attempts >= session.settings.MaxRetransmits || now.Sub(entry.sentAt) < session.settings.AckTimeout {
+			continue
+		}
+
+		entry.sentAt = now
+		entry.attempts++
+
+		if err := client.writePushMessage(&entry.message); err != nil {
+			log.Errorf("got an error retransmitting push message to websocket client {%s}: %s", client.Id, err.Error())
+			return
+		}
+	}
+}
+
+// Detaches the client keeping its channel subscriptions. Ignored if the session has been taken over by another client.
+func (session *websocketSession) detach(client *WebsocketClient, now time.Time) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if session.client != client {
+		return
+	}
+
+	session.client = nil
+	session.channels = append([]uuid.UUID{}, client.getChannels()...)
+	session.presence, _ = client.getPresence()
+	session.detachedAt = now
+
+	// Keep the messages sent to the channels for the client expected to reconnect.
+	client.server.channels.detachSession(client, session, session.channels)
+}
+
+// Drops the channel from the subscriptions restored on resume.
+func (session *websocketSession) removeChannel(channelId uuid.UUID) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	for idx, v := range session.channels {
+		if v == channelId {
+			session.channels = append(append([]uuid.UUID{}, session.channels[:idx]...), session.channels[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (session *websocketSession) isDetached() bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.client == nil
+}
+
+func (session *websocketSession) getChannels() []uuid.UUID {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return append([]uuid.UUID{}, session.channels...)
+}
+
+// Sessions of the clients by the session token.
+type websocketSessionStore struct {
+	lock     sync.Mutex
+	sessions map[string]*websocketSession
+	settings WebsocketSessionSettings
+}
+
+func newWebsocketSessionStore(settings WebsocketSessionSettings) *websocketSessionStore {
+	return &websocketSessionStore{
+		sessions: make(map[string]*websocketSession),
+		settings: settings,
+	}
+}
+
+// Reads the "rpc.session" configuration section.
+func newWebsocketSessionSettingsFromConfig() WebsocketSessionSettings {
+	return WebsocketSessionSettings{
+		ResumeWindow:   config.GetDuration("rpc.session.resumeWindow"),
+		BufferSize:     config.GetInt("rpc.session.bufferSize"),
+		AckTimeout:     config.GetDuration("rpc.session.ackTimeout"),
+		MaxRetransmits: config.GetInt("rpc.session.maxRetransmits"),
+	}
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Starts a new session attached to the client.
+func (store *websocketSessionStore) create(client *WebsocketClient) (*websocketSession, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &websocketSession{
+		token:    token,
+		userId:   client.user.Id,
+		client:   client,
+		settings: store.settings,
+	}
+
+	store.lock.Lock()
+	store.sessions[token] = session
+	store.lock.Unlock()
+
+	return session, nil
+}
+
+func (store *websocketSessionStore) get(token string) *websocketSession {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.sessions[token]
+}
+
+// Drops the sessions detached for longer than the resume window. Returns the dropped sessions.
+func (store *websocketSessionStore) prune(now time.Time) []*websocketSession {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var expired []*websocketSession
+	for token, session := range store.sessions {
+		session.lock.Lock()
+		isExpired := session.client == nil && now.Sub(session.detachedAt) > store.settings.ResumeWindow
+		session.lock.Unlock()
+
+		if isExpired {
+			delete(store.sessions, token)
+			expired = append(expired, session)
+		}
+	}
+
+	return expired
+}
+
+// Starts a new session for the authenticated client.
+func (client *WebsocketClient) startSession() (*WebsocketSessionInfo, error) {
+	session, err := client.server.sessions.create(client)
+	if err != nil {
+		return nil, err
+	}
+
+	client.setSession(session)
+
+	return &WebsocketSessionInfo{Token: session.token}, nil
+}
+
+// Attaches the client to the existing session, restores its channel subscriptions, responds to the connect request and
+// replays the push messages sent after the last sequence number received by the client. The response and the replayed
+// messages are sent under the session lock, so they precede any new push messages.
+func (client *WebsocketClient) resumeSession(websocketMessage *WebsocketMessage, token string, lastSeq uint64) error {
+	session := client.server.sessions.get(token)
+	if session == nil {
+		return errSessionNotFound
+	}
+
+	// The previous connection is closed once the session is unlocked, as writing the close frame may block.
+	var previous *WebsocketClient
+	defer func() {
+		if previous != nil {
+			previous.disconnect(websocket.CloseNormalClosure, "session resumed by another connection")
+		}
+	}()
+
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if session.userId != client.user.Id {
+		return errSessionUserMismatch
+	}
+
+	if !session.canReplayFrom(lastSeq) {
+		return errSessionReplayGap
+	}
+
+	// Messages received before the disconnect are implicitly acknowledged.
+	session.acknowledge(lastSeq)
+
+	// The previous connection may still be open if the client has reconnected before the server noticed the drop.
+	if previous = session.client; previous != nil {
+		session.channels = append([]uuid.UUID{}, previous.getChannels()...)
+		session.presence, _ = previous.getPresence()
+		client.server.channels.removeClient(previous, session.channels)
+	}
+
+	session.client = client
+	session.detachedAt = time.Time{}
+
+	// Keep the private channels the client has joined during the handshake along with the restored ones.
+	channels := append([]uuid.UUID{}, session.channels...)
+	for _, channelId := range client.getChannels() {
+		if !containsUUID(channels, channelId) {
+			channels = append(channels, channelId)
+		}
+	}
+
+	client.setSession(session)
+	client.setChannels(channels)
+	client.setPresence(session.presence, time.Now())
+	client.server.channels.attachSession(client, session, session.channels)
+
+	log.Printf("client {%s} resumed the session of user {%s}, channels: {%d}, last seq: {%d}, replaying from: {%d}", client.Id, session.userId, len(client.getChannels()), session.lastSeq, lastSeq)
+
+	result := WebsocketPayload{
+		Status:  handlerStatusOk,
+		Sender:  client.user,
+		Session: &WebsocketSessionInfo{Token: session.token, Resumed: true, LastSeq: session.lastSeq},
+	}
+	if err := client.sendResponseMessage(websocketMessage, result); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range session.buffer {
+		session.buffer[i].sentAt = now
+		if err := client.writePushMessage(&session.buffer[i].message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handles the acknowledgement of the push messages up to the sequence number.
+func (client *WebsocketClient) acknowledge(seq uint64) {
+	session := client.getSession()
+	if session == nil {
+		return
+	}
+
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	session.acking = true
+	session.acknowledge(seq)
+}
+
+// Retransmits the push messages unacknowledged by the client.
+func (client *WebsocketClient) retransmitUnacknowledged(now time.Time) {
+	if session := client.getSession(); session != nil {
+		session.retransmit(client, now)
+	}
+}
+
+// Detaches the disconnected client from its session.
+func (client *WebsocketClient) detachSession(now time.Time) {
+	if session := client.getSession(); session != nil {
+		session.detach(client, now)
+	}
+}
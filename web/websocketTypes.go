@@ -11,6 +11,9 @@ const (
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 1 << 20
+
+	// Period of checking unacknowledged push messages for retransmission.
+	retransmitPeriod = time.Second
 )
 
 type WebsocketMessageType int32
@@ -37,12 +40,23 @@ const (
 	CategorySpace   string = "space"
 	CategoryServer  string = "server"
 	CategoryPrivate string = "private"
+	CategoryGroup   string = "group"
 	CategoryUnknown string = "unknown"
 )
 
 const (
 	MessageNotifyUserJoinedChannel string = "userJoinedChannel"
 	MessageNotifyUserLeftChannel   string = "userLeftChannel"
+	MessageNotifyMessageEdited     string = "messageEdited"
+	MessageNotifyMessageDeleted    string = "messageDeleted"
+	// Private channel messages sent while the user was offline.
+	MessageNotifyPrivateChannelBacklog string = "privateChannelBacklog"
+	// Group membership changes, sent to the group channel.
+	MessageNotifyGroupMemberAdded   string = "groupMemberAdded"
+	MessageNotifyGroupMemberRemoved string = "groupMemberRemoved"
+	// Group membership changes, sent to the invited or kicked user.
+	MessageNotifyGroupInvited string = "groupInvited"
+	MessageNotifyGroupKicked  string = "groupKicked"
 )
 
 const (
@@ -54,10 +68,19 @@ const (
 
 const (
 	ConnectMethod            string = "connect"            // Connect to the server. Initial websocket connection handshake.
+	PingMethod               string = "ping"               // Get the server time and the round trip time.
 	PresenceUpdateMethod     string = "presenceUpdate"     // Connect to the server. Initial websocket connection handshake.
 	ChannelSubscribeMethod   string = "channelSubscribe"   // Subscribe to existing channel. Used to connect to known channel, e.g. global or space channels.
 	ChannelUnsubscribeMethod string = "channelUnsubscribe" // Unsubscribe from the channel. Used when user leaves space to stop to receive local space messages.
 	ChannelSendMethod        string = "channelSend"        // Send the message to the channel.
+	ChannelHistoryMethod     string = "channelHistory"     // Get the page of the messages sent to the channel.
+	MessageEditMethod        string = "messageEdit"        // Edit the message sent to the channel.
+	MessageDeleteMethod      string = "messageDelete"      // Delete the message sent to the channel.
+	GroupCreateMethod        string = "groupCreate"        // Create the group channel owned by the user.
+	GroupInviteMethod        string = "groupInvite"        // Add the user to the group channel.
+	GroupKickMethod          string = "groupKick"          // Remove the user from the group channel.
+	GroupLeaveMethod         string = "groupLeave"         // Leave the group channel.
+	GroupListMethod          string = "groupList"          // Get the group channels of the user.
 	UserChangeNameMethod     string = "userChangeName"     // Change user's name.
 	UserActionMethod         string = "userAction"         // Report user action.
 	VivoxGetLoginTokenMethod string = "vivoxGetLoginToken" // Request vivox token.
@@ -67,12 +90,73 @@ const (
 	VivoxKickMethod          string = "vivoxKick"          // Request vivox server-to-server action.
 )
 
+// Push messages sent by the clients.
+const (
+	AckMethod string = "ack" // Acknowledge the push messages up to the sequence number, received without gaps.
+)
+
+// Methods implemented by the clients, called by the server.
+const (
+	TeleportConfirmMethod  string = "teleportConfirm"  // Ask the player to confirm the teleport.
+	LocalStateReportMethod string = "localStateReport" // Ask the client to report its local state.
+)
+
 type WebsocketPayload struct {
-	Status    string       `json:"status,omitempty"`
-	Message   string       `json:"message,omitempty"`
-	Sender    *models.User `json:"sender,omitempty"`
-	ChannelId string       `json:"channelId,omitempty"`
-	Category  string       `json:"category,omitempty"`
+	Status    string                  `json:"status,omitempty"`
+	Message   string                  `json:"message,omitempty"`
+	MessageId string                  `json:"messageId,omitempty"` // Set for the channel messages.
+	Sender    *models.User            `json:"sender,omitempty"`
+	ChannelId string                  `json:"channelId,omitempty"`
+	Category  string                  `json:"category,omitempty"`
+	Error     *WebsocketError         `json:"error,omitempty"`   // Set for the error responses.
+	Session   *WebsocketSessionInfo   `json:"session,omitempty"` // Set for the connect responses.
+	Time      *WebsocketTimeInfo      `json:"time,omitempty"`    // Set for the ping responses.
+	History   *WebsocketHistory       `json:"history,omitempty"` // Set for the channel history responses.
+	Update    *WebsocketMessageUpdate `json:"update,omitempty"`  // Set for the message edited and deleted notifications.
+	Group     *WebsocketGroup         `json:"group,omitempty"`   // Set for the group channel responses and notifications.
+	Groups    []WebsocketGroup        `json:"groups,omitempty"`  // Set for the group list responses.
+}
+
+// Group channel with its members.
+type WebsocketGroup struct {
+	Id        string       `json:"id"`
+	Name      string       `json:"name"`
+	OwnerId   uuid.UUID    `json:"ownerId"`
+	MemberIds []uuid.UUID  `json:"memberIds"`
+	Member    *models.User `json:"member,omitempty"` // User added or removed, set for the membership notifications.
+}
+
+// Change of the channel message.
+type WebsocketMessageUpdate struct {
+	MessageId string `json:"messageId"`
+	Message   string `json:"message,omitempty"`   // Text of the edited message.
+	EditedAt  int64  `json:"editedAt,omitempty"`  // Unix milliseconds.
+	DeletedAt int64  `json:"deletedAt,omitempty"` // Unix milliseconds.
+}
+
+// Server time and the round trip time to estimate the clock offset of the client:
+// offset = serverTime - (clientTime + rtt / 2).
+type WebsocketTimeInfo struct {
+	ClientTime int64   `json:"clientTime,omitempty"` // Client time passed with the ping, unix milliseconds.
+	ServerTime int64   `json:"serverTime"`           // Server time handling the ping, unix milliseconds.
+	Rtt        float64 `json:"rtt"`                  // Smoothed round trip time measured with the websocket pings, milliseconds. Zero until measured.
+}
+
+// Page of the channel messages in the chronological order.
+type WebsocketHistory struct {
+	Messages []WebsocketHistoryMessage `json:"messages"`
+	HasMore  bool                      `json:"hasMore"` // Set if there are more messages beyond the page in the requested direction.
+}
+
+type WebsocketHistoryMessage struct {
+	Id        string       `json:"id"`
+	Message   string       `json:"message"`
+	Sender    *models.User `json:"sender"`
+	ChannelId string       `json:"channelId"`
+	Category  string       `json:"category"`
+	CreatedAt int64        `json:"createdAt"`          // Unix milliseconds.
+	EditedAt  int64        `json:"editedAt,omitempty"` // Unix milliseconds, set if the message has been edited.
+	Deleted   bool         `json:"deleted,omitempty"`  // Set if the message has been deleted, the text is not returned.
 }
 
 type WebsocketMessage struct {
@@ -82,4 +166,5 @@ type WebsocketMessage struct {
 	Method  string               `json:"method,omitempty"`  // Used to determine the handler method to process.
 	Payload interface{}          `json:"payload,omitempty"` // Used for responses and push messages.
 	Args    interface{}          `json:"args,omitempty"`    // Used for requests.
+	Seq     uint64               `json:"seq,omitempty"`     // Sequence number of the push message within the session.
 }
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	"dev.hackerman.me/artheon/artheon-rpc/models"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rank of the device presence status, the presence of the user is taken from the device with the highest rank.
+var presenceStatusRanks = map[string]int{
+	PresenceStatusOffline:   0,
+	PresenceStatusAway:      1,
+	PresenceStatusAvailable: 2,
+	PresenceStatusPlaying:   3,
+}
+
+// Devices of the user connected to this instance, e.g. the game client and the web portal.
+type websocketUser struct {
+	clients map[uuid.UUID]*WebsocketClient
+	// Private channels joined by all the devices of the user.
+	privateChannels []uuid.UUID
+}
+
+// Connected users by the user id.
+type websocketUserRegistry struct {
+	lock  sync.RWMutex
+	users map[uuid.UUID]*websocketUser
+}
+
+func newWebsocketUserRegistry() *websocketUserRegistry {
+	return &websocketUserRegistry{
+		users: make(map[uuid.UUID]*websocketUser),
+	}
+}
+
+// Adds the device of the user unless the user has the maximal number of the devices connected, zero allows any.
+// Returns the private channels of the user the device must join.
+func (registry *websocketUserRegistry) add(userId uuid.UUID, client *WebsocketClient, maxClients int) ([]uuid.UUID, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	user, ok := registry.users[userId]
+	if !ok {
+		user = &websocketUser{clients: make(map[uuid.UUID]*WebsocketClient)}
+		registry.users[userId] = user
+	}
+
+	if _, ok = user.clients[client.Id]; !ok && maxClients > 0 && len(user.clients) >= maxClients {
+		return nil, errTooManyUserConnections
+	}
+
+	user.clients[client.Id] = client
+
+	return append([]uuid.UUID{}, user.privateChannels...), nil
+}
+
+// Removes the device of the user. Returns the number of the remaining devices.
+func (registry *websocketUserRegistry) remove(userId uuid.UUID, client *WebsocketClient) int {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	user, ok := registry.users[userId]
+	if !ok {
+		return 0
+	}
+
+	delete(user.clients, client.Id)
+	if len(user.clients) == 0 {
+		delete(registry.users, userId)
+	}
+
+	return len(user.clients)
+}
+
+// Returns the number of the devices of the user connected to this instance.
+func (registry *websocketUserRegistry) count(userId uuid.UUID) int {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	user, ok := registry.users[userId]
+	if !ok {
+		return 0
+	}
+	return len(user.clients)
+}
+
+// Returns the devices of the user connected to this instance.
+func (registry *websocketUserRegistry) getClients(userId uuid.UUID) []*WebsocketClient {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	user, ok := registry.users[userId]
+	if !ok {
+		return nil
+	}
+
+	clients := make([]*WebsocketClient, 0, len(user.clients))
+	for _, client := range user.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Records the private channel of the user, so the devices connected later join it too. Returns the devices of the
+// user which must join the channel.
+func (registry *websocketUserRegistry) addPrivateChannel(userId uuid.UUID, channelId uuid.UUID) []*WebsocketClient {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	user, ok := registry.users[userId]
+	if !ok {
+		return nil
+	}
+
+	if !containsUUID(user.privateChannels, channelId) {
+		user.privateChannels = append(user.privateChannels, channelId)
+	}
+
+	clients := make([]*WebsocketClient, 0, len(user.clients))
+	for _, client := range user.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Computes the presence of the user across the devices. The most active device wins, the latest updated one if tied,
+// e.g. the user is playing if any of the devices is in game.
+func (registry *websocketUserRegistry) getPresence(userId uuid.UUID) (models.Presence, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	user, ok := registry.users[userId]
+	if !ok {
+		return models.Presence{}, false
+	}
+
+	var presence models.Presence
+	var updatedAt time.Time
+	rank := -1
+
+	for _, client := range user.clients {
+		devicePresence, deviceUpdatedAt := client.getPresence()
+		deviceRank := presenceStatusRanks[devicePresence.Status]
+
+		if deviceRank > rank || (deviceRank == rank && deviceUpdatedAt.After(updatedAt)) {
+			presence, updatedAt, rank = devicePresence, deviceUpdatedAt, deviceRank
+		}
+	}
+
+	if presence.Status == "" {
+		presence.Status = PresenceStatusOffline
+	}
+
+	return presence, true
+}
+
+// Updates the presence of this device, then stores the presence of the user computed across the devices.
+func (client *WebsocketClient) updatePresence(status string, spaceId uuid.UUID, serverId uuid.UUID) error {
+	client.setPresence(models.Presence{Status: status, UserId: client.user.Id, SpaceId: spaceId, ServerId: serverId}, time.Now())
+
+	presence, ok := client.server.users.getPresence(client.user.Id)
+	if !ok {
+		presence = client.presence
+	}
+
+	return client.user.UpdateUserPresence(client.server.store, presence.Status, presence.SpaceId, presence.ServerId)
+}
+
+// Stores the presence of the user computed across the remaining devices and notifies the followers.
+func (server *WebsocketServer) refreshUserPresence(user *models.User) {
+	presence, ok := server.users.getPresence(user.Id)
+	if !ok {
+		return
+	}
+
+	if err := user.UpdateUserPresence(server.store, presence.Status, presence.SpaceId, presence.ServerId); err != nil {
+		log.Errorf("got an error updating presence of user {%s}: %s", user.Id, err.Error())
+		return
+	}
+
+	if err := server.notifyUserPresenceChanged(server.SystemChannel, user); err != nil {
+		log.Errorf("got an error notifying presence of user {%s}: %s", user.Id, err.Error())
+	}
+}
+
+// Stores the offline presence of the user whose session has expired and notifies the followers, unless the user has
+// connected again.
+func (server *WebsocketServer) expireUserPresence(userId uuid.UUID) {
+	if server.users.count(userId) > 0 {
+		return
+	}
+
+	user, err := server.store.GetUserById(userId)
+	if err != nil {
+		log.Errorf("got an error loading user {%s}: %s", userId, err.Error())
+		return
+	}
+
+	if err := user.UpdateUserPresence(server.store, PresenceStatusOffline, uuid.Nil, uuid.Nil); err != nil {
+		log.Errorf("got an error setting presence of user {%s} offline: %s", userId, err.Error())
+		return
+	}
+
+	if err := server.notifyUserPresenceChanged(server.SystemChannel, user); err != nil {
+		log.Errorf("got an error notifying presence of user {%s}: %s", userId, err.Error())
+	}
+}